@@ -50,6 +50,128 @@ func Test_Checksum(t *testing.T) {
 	}
 }
 
+func Test_Checksum_IPv6(t *testing.T) {
+	var (
+		process = netip.MustParseAddrPort("[2001:db8::1]:19986")
+		local   = netip.MustParseAddrPort("[2001:db8::2]:1234")
+		server  = netip.MustParseAddrPort("[2001:db8::3]:443")
+		link    = links.Downlink{Server: server, Proto: header.TCPProtocolNumber, Local: local}
+		raw     = BuildRawTCP6(t, process, server, []byte("hello"))
+
+		pkt = packet.Make(40, 0, len(raw)).Append(raw...)
+		tcp = checksum.Client(pkt)
+		ip  = checksum.Server(tcp, link)
+	)
+
+	hdr := header.IPv6(ip.Bytes())
+	require.True(t, hdr.IsValid(len(hdr)))
+	require.Equal(t, local.Addr().As16(), hdr.SourceAddress().As16())
+	require.Equal(t, server.Addr().As16(), hdr.DestinationAddress().As16())
+
+	tcph := header.TCP(hdr.Payload())
+	require.Equal(t, local.Port(), tcph.SourcePort())
+	require.Equal(t, server.Port(), tcph.DestinationPort())
+	psum := header.PseudoHeaderChecksum(
+		header.TCPProtocolNumber,
+		hdr.SourceAddress(), hdr.DestinationAddress(),
+		uint16(len(tcph)),
+	)
+	require.Equal(t, uint16(0xffff), stdsum.Checksum(tcph, psum))
+}
+
+func BuildRawTCP6(t require.TestingT, laddr, raddr netip.AddrPort, tcpPayload []byte) header.IPv6 {
+	var ip = make(header.IPv6, header.IPv6MinimumSize+header.TCPMinimumSize+len(tcpPayload))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(header.TCPMinimumSize + len(tcpPayload)),
+		TransportProtocol: header.TCPProtocolNumber,
+		HopLimit:          64,
+		SrcAddr:           tcpip.AddrFrom16(laddr.Addr().As16()),
+		DstAddr:           tcpip.AddrFrom16(raddr.Addr().As16()),
+	})
+
+	tcp := header.TCP(ip.Payload())
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    laddr.Port(),
+		DstPort:    raddr.Port(),
+		SeqNum:     rand.Uint32(),
+		AckNum:     rand.Uint32(),
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagSyn,
+		WindowSize: uint16(rand.Uint32()),
+	})
+	copy(tcp.Payload(), tcpPayload)
+
+	sum := header.PseudoHeaderChecksum(
+		header.TCPProtocolNumber,
+		ip.SourceAddress(), ip.DestinationAddress(),
+		uint16(len(tcp)),
+	)
+	tcp.SetChecksum(^stdsum.Checksum(tcp, sum))
+	return ip
+}
+
+func Test_Checksum_ICMP(t *testing.T) {
+	var (
+		process = netip.AddrPortFrom(netip.MustParseAddr("10.0.0.2"), 1234) // echo identifier
+		local   = netip.AddrPortFrom(netip.MustParseAddr("10.0.0.3"), 4321)
+		server  = netip.AddrPortFrom(netip.MustParseAddr("8.8.8.8"), 0)
+		link    = links.Downlink{Server: server, Proto: header.ICMPv4ProtocolNumber, Local: local}
+	)
+
+	var raw = make(header.IPv4, header.IPv4MinimumSize+header.ICMPv4MinimumSize+4)
+	raw.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(raw)),
+		TTL:         64,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(process.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(server.Addr().As4()),
+	})
+	icmp := header.ICMPv4(raw.Payload())
+	icmp.SetType(header.ICMPv4Echo)
+	icmp.SetIdent(process.Port())
+	icmp.SetSequence(1)
+	copy(icmp.Payload(), "ping")
+	icmp.SetChecksum(^stdsum.Checksum(icmp, 0))
+
+	pkt := packet.Make(20, 0, len(raw)).Append(raw...)
+	ip := checksum.Server(checksum.Client(pkt), link)
+
+	hdr := header.IPv4(ip.Bytes())
+	require.Equal(t, server.Addr().As4(), hdr.DestinationAddress().As4())
+	icmp = header.ICMPv4(hdr.Payload())
+	require.Equal(t, local.Port(), icmp.Ident())
+	require.Equal(t, uint16(0xffff), stdsum.Checksum(icmp, 0))
+}
+
+func Test_Checksum_Generic(t *testing.T) {
+	const esp = 50
+	var (
+		process = netip.AddrPortFrom(netip.MustParseAddr("10.0.0.2"), 0)
+		local   = netip.AddrPortFrom(netip.MustParseAddr("10.0.0.3"), 0)
+		server  = netip.AddrPortFrom(netip.MustParseAddr("8.8.8.8"), 0)
+		link    = links.Downlink{Server: server, Proto: esp, Local: local}
+	)
+
+	var raw = make(header.IPv4, header.IPv4MinimumSize+16)
+	raw.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(raw)),
+		TTL:         64,
+		Protocol:    esp,
+		SrcAddr:     tcpip.AddrFrom4(process.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(server.Addr().As4()),
+	})
+	copy(raw.Payload(), "esp spi and data")
+
+	pkt := packet.Make(20, 0, len(raw)).Append(raw...)
+	ip := checksum.Server(checksum.Client(pkt), link)
+
+	hdr := header.IPv4(ip.Bytes())
+	require.True(t, hdr.IsValid(len(hdr)))
+	require.Equal(t, local.Addr().As4(), hdr.SourceAddress().As4())
+	require.Equal(t, uint8(esp), hdr.Protocol())
+	require.Equal(t, "esp spi and data", string(hdr.Payload()))
+}
+
 func BuildRawTCP(t require.TestingT, laddr, raddr netip.AddrPort, tcpPayload []byte) header.IPv4 {
 	var ip = make(header.IPv4, header.IPv4MinimumSize+header.TCPMinimumSize+len(tcpPayload))
 	ip.Encode(&header.IPv4Fields{
@@ -1,6 +1,7 @@
 package checksum
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"sync/atomic"
@@ -19,43 +20,64 @@ import (
 	uplink:
 		client 使用传输层checksum的标准计算方法, 只是将src-port, PseudoHeader中的src-ip视为0。
 		server 则可以根据client的计算约定, 快速求出实际的checksum。
+		icmp echo 的 identifier 视为src-port, icmpv4 没有PseudoHeader。
+		gre/sctp/esp 等没有端口的协议, checksum 与ip地址无关, 不做任何修改。
 
 	downlink:
 		server 不计算checksum, 在client重新计算。
 */
 
 func Client(ip *packet.Packet) (pkt *packet.Packet) {
-	hdr := header.IPv4(ip.Bytes())
-	if header.IPVersion(hdr) != 4 {
-		panic("only support ipv4")
+	var (
+		proto   tcpip.TransportProtocolNumber
+		dst     tcpip.Address
+		zero    tcpip.Address
+		payload []byte
+		hdrLen  int
+	)
+	switch ver := header.IPVersion(ip.Bytes()); ver {
+	case 4:
+		hdr := header.IPv4(ip.Bytes())
+		proto, dst, zero = hdr.TransportProtocol(), hdr.DestinationAddress(), ip4zero
+		payload, hdrLen = hdr.Payload(), int(hdr.HeaderLength())
+	case 6:
+		hdr := header.IPv6(ip.Bytes())
+		proto, dst, zero = hdr.TransportProtocol(), hdr.DestinationAddress(), ip6zero
+		payload, hdrLen = hdr.Payload(), header.IPv6FixedHeaderSize
+	default:
+		panic(fmt.Sprintf("not support ip version %d", ver))
 	}
 
-	var t header.Transport
-	switch hdr.TransportProtocol() {
+	var t transport
+	switch proto {
 	case header.TCPProtocolNumber:
-		t = header.TCP(hdr.Payload())
+		t = header.TCP(payload)
 	case header.UDPProtocolNumber:
-		t = header.UDP(hdr.Payload())
+		t = header.UDP(payload)
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		t = echo(payload)
 	default:
-		panic(fmt.Sprintf("not support protocole %d", hdr.TransportProtocol()))
+		if !links.IsGeneric(proto) {
+			panic(fmt.Sprintf("not support protocole %d", proto))
+		}
+		return ip.SetHead(ip.Head() + hdrLen)
 	}
 
 	srcPort := t.SourcePort()
 	t.SetSourcePort(0)
 	t.SetChecksum(0)
-	sum := header.PseudoHeaderChecksum(
-		hdr.TransportProtocol(),
-		ip4zero,
-		hdr.DestinationAddress(),
-		uint16(len(hdr.Payload())),
-	)
-	t.SetChecksum(^checksum.Checksum(hdr.Payload(), sum))
+	var sum uint16
+	if proto != header.ICMPv4ProtocolNumber { // icmpv4 not pseudo header
+		sum = header.PseudoHeaderChecksum(proto, zero, dst, uint16(len(payload)))
+	}
+	t.SetChecksum(^checksum.Checksum(payload, sum))
 	t.SetSourcePort(srcPort)
 
-	return ip.SetHead(ip.Head() + int(hdr.HeaderLength()))
+	return ip.SetHead(ip.Head() + hdrLen)
 }
 
 var ip4zero = tcpip.AddrFrom4([4]byte{})
+var ip6zero = tcpip.AddrFrom16([16]byte{})
 var ip4id = atomic.Uint32{}
 
 func init() {
@@ -63,28 +85,51 @@ func init() {
 }
 
 func Server(pkt *packet.Packet, down links.Downlink) (ip *packet.Packet) {
-	sum := checksum.Checksum(down.Local.Addr().AsSlice(), down.Local.Port())
+	var sum = down.Local.Port()
+	if down.Proto != header.ICMPv4ProtocolNumber {
+		sum = checksum.Checksum(down.Local.Addr().AsSlice(), down.Local.Port())
+	}
 
-	var t header.Transport
+	var t transport
 	switch down.Proto {
 	case header.TCPProtocolNumber:
 		t = header.TCP(pkt.Bytes())
 	case header.UDPProtocolNumber:
 		t = header.UDP(pkt.Bytes())
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		t = echo(pkt.Bytes())
 	default:
-		panic(fmt.Sprintf("not support protocole %d", down.Proto))
+		if !links.IsGeneric(down.Proto) {
+			panic(fmt.Sprintf("not support protocole %d", down.Proto))
+		}
+	}
+	if t != nil {
+		t.SetChecksum(^checksum.Combine(sum, ^t.Checksum()))
+		t.SetSourcePort(down.Local.Port())
 	}
-	t.SetChecksum(^checksum.Combine(sum, ^t.Checksum()))
-	t.SetSourcePort(down.Local.Port())
-	if debug.Debug() {
+	if debug.Debug() && t != nil && down.Proto != header.ICMPv4ProtocolNumber {
 		test.ValidTCP(test.T(), pkt.Bytes(), header.PseudoHeaderChecksum(
 			down.Proto,
-			tcpip.AddrFrom4(down.Local.Addr().As4()),
-			tcpip.AddrFrom4(down.Server.Addr().As4()),
+			tcpip.AddrFromSlice(down.Local.Addr().AsSlice()),
+			tcpip.AddrFromSlice(down.Server.Addr().AsSlice()),
 			0,
 		))
 	}
 
+	if down.Local.Addr().Is6() {
+		n := len(pkt.Bytes())
+		hdr := header.IPv6(pkt.AttachN(header.IPv6MinimumSize).Bytes())
+		hdr.Encode(&header.IPv6Fields{
+			TrafficClass:      0b00001110,
+			PayloadLength:     uint16(n),
+			TransportProtocol: down.Proto,
+			HopLimit:          64,
+			SrcAddr:           tcpip.AddrFrom16(down.Local.Addr().As16()),
+			DstAddr:           tcpip.AddrFrom16(down.Server.Addr().As16()),
+		})
+		return pkt
+	}
+
 	hdr := header.IPv4(pkt.AttachN(header.IPv4MinimumSize).Bytes())
 
 	// notice: IPConn can set TotalLength/ID/Checksum/SrcAddr automatically, but ETHConn can't
@@ -105,3 +150,18 @@ func Server(pkt *packet.Packet, down links.Downlink) (ip *packet.Packet) {
 
 	return pkt
 }
+
+type transport interface {
+	SourcePort() uint16
+	SetSourcePort(port uint16)
+	Checksum() uint16
+	SetChecksum(sum uint16)
+}
+
+// echo icmp/icmpv6 echo message, the identifier as source port
+type echo []byte
+
+func (e echo) SourcePort() uint16        { return binary.BigEndian.Uint16(e[4:]) }
+func (e echo) SetSourcePort(port uint16) { binary.BigEndian.PutUint16(e[4:], port) }
+func (e echo) Checksum() uint16          { return binary.BigEndian.Uint16(e[2:]) }
+func (e echo) SetChecksum(sum uint16)    { binary.BigEndian.PutUint16(e[2:], sum) }
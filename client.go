@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/hex"
-	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/netip"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lysShub/fatun/checksum"
 	"github.com/lysShub/fatun/conn"
 	"github.com/lysShub/fatun/conn/udp"
+	"github.com/lysShub/fatun/links"
 	"github.com/lysShub/rawsock/test"
 	"github.com/pkg/errors"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -31,6 +35,19 @@ type Capturer interface {
 	Close() error
 }
 
+// Passer Capturer can send the captured packet by origin path, use for Direct flow
+type Passer interface {
+	Pass(ip *packet.Packet) error
+}
+
+// Filter select the flow will be captured, flows not selected will be pass directly
+type Filter func(s Session) (capture bool)
+
+// Filterer Capturer support filter flows before capture
+type Filterer interface {
+	SetFilter(f Filter)
+}
+
 type Client struct {
 	// Logger Warn/Error logger
 	Logger      *slog.Logger
@@ -39,11 +56,39 @@ type Client struct {
 
 	Conn conn.Conn
 
+	// Reconnect re-establish the tunnel conn if it failed, nil will close the
+	// client, see Reconnect.
+	Reconnect *Reconnect
+
 	Capturer Capturer
 
+	// Rules split tunneling rules, nil will tunnel all captured flows. rule with
+	// domain depend on Rules.DNS, that record by snooped dns response, of both
+	// tunneled and direct flows.
+	Rules *Rules
+
+	// Sniff sniff new flow's server name from first payloads, see Sniff, the name
+	// be used by rule with domain, require Rules. tcp flow be terminated locally
+	// until sniffed, then connect to server by the decided action, see splicer.
+	Sniff    bool
+	sessions *sessions
+	splice   *splicer // nil if capturer not support, see splicer
+
 	PcapCapturer *pcap.Pcap
 
+	// newCapture create Capturer if not set, default NewDefaultCapture
+	newCapture func(laddr netip.AddrPort, overhead int) (Capturer, error)
+
 	peer     conn.Peer
+	tunnel   atomic.Pointer[conn.Conn] // current tunnel conn, swapped by reconnect
+	downMu   sync.Mutex
+	down     atomic.Bool
+	ready    chan struct{} // closed after reconnected
+	buffered []*packet.Packet
+	dropped  atomic.Uint64
+	local4   atomic.Pointer[netip.Addr] // see setLocal
+	local6   atomic.Pointer[netip.Addr]
+	stale    atomic.Bool // capturer rebind failed after roamed, see roam
 	srvCtx   context.Context
 	cancel   context.CancelFunc
 	closeErr errorx.CloseErr
@@ -61,21 +106,74 @@ func NewClient[P conn.Peer](opts ...func(*Client)) (*Client, error) {
 		c.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	}
 	if c.Conn == nil {
-		u, err := udp.Dial(nil, &net.UDPAddr{Port: DefaultPort})
-		if err != nil {
-			return nil, c.close(err)
+		dial := func(ctx context.Context, laddr netip.AddrPort) (conn.Conn, error) {
+			// source address maybe changed, keep port only
+			u, err := udp.Dial(&net.UDPAddr{Port: int(laddr.Port())}, &net.UDPAddr{Port: DefaultPort})
+			if err != nil {
+				return nil, err
+			}
+			return conn.NewConn[P](u, &conn.Config{MaxRecvBuff: c.MaxRecvBuff})
 		}
-		c.Conn, err = conn.NewConn[P](u, &conn.Config{MaxRecvBuff: c.MaxRecvBuff})
-		if err != nil {
+		if c.Reconnect != nil && c.Reconnect.Dial == nil {
+			c.Reconnect.Dial = dial
+		}
+
+		var err error
+		if c.Conn, err = dial(c.srvCtx, netip.AddrPort{}); err != nil {
 			return nil, c.close(err)
 		}
+	} else if c.Reconnect != nil && c.Reconnect.Dial == nil {
+		return nil, c.close(errors.New("require Reconnect.Dial"))
 	}
+	c.tunnel.Store(&c.Conn)
+	c.ready = make(chan struct{})
 
 	var err error
 	if c.Capturer == nil {
-		c.Capturer, err = NewDefaultCapture(c.Conn.LocalAddr(), c.TcpMssDelta)
+		if c.newCapture == nil {
+			c.newCapture = NewDefaultCapture
+		}
+		capturer, err := c.newCapture(c.Conn.LocalAddr(), c.TcpMssDelta)
 		if err != nil {
-			return nil, err
+			return nil, c.close(err)
+		}
+		c.Capturer = capturer
+	}
+
+	if c.Rules != nil {
+		c.sessions = newSessions()
+		c.Rules.onReplace(c.sessions.invalidate)
+		if c.Rules.DNS == nil {
+			c.Rules.DNS = NewDNSCache()
+		}
+		if c.Rules.Mapping == nil {
+			if c.Rules.Mapping, err = newProcessMapping(); err != nil {
+				c.Logger.Warn(err.Error(), errorx.Trace(err))
+			}
+		}
+		_, pass := c.Capturer.(Passer)
+		f, filterer := c.Capturer.(Filterer)
+		if c.Sniff && (pass || filterer) {
+			if c.splice, err = newSplicer(c); err != nil {
+				return nil, c.close(err)
+			}
+			c.Rules.onReplace(c.splice.rematch)
+		}
+		if filterer {
+			// capturer cache per-flow verdict, reset it after rules replaced
+			filter := func(s Session) bool {
+				if c.splice != nil {
+					if a, ok := c.splice.exempt(s); ok {
+						return a != Direct
+					} else if s.Proto == header.TCPProtocolNumber {
+						return true // action decided after spliced
+					}
+				}
+				a, _ := c.match(s, "")
+				return a != Direct
+			}
+			f.SetFilter(filter)
+			c.Rules.onReplace(func() { f.SetFilter(filter) })
 		}
 	}
 	return c, nil
@@ -84,6 +182,16 @@ func NewClient[P conn.Peer](opts ...func(*Client)) (*Client, error) {
 func (c *Client) Run() {
 	go c.uplinkService()
 	go c.downlinkServic()
+	go c.roamService()
+	if c.splice != nil {
+		go c.splice.serve()
+	}
+	if c.sessions != nil {
+		go c.dnsSnoopService()
+	}
+	if c.sessions != nil && c.Sniff {
+		go c.sniffService()
+	}
 }
 
 func (c *Client) close(cause error) (_ error) {
@@ -99,12 +207,22 @@ func (c *Client) close(cause error) (_ error) {
 		if c.cancel != nil {
 			c.cancel()
 		}
+		if c.splice != nil {
+			errs = append(errs, c.splice.close())
+		}
 		if c.Capturer != nil {
 			errs = append(errs, c.Capturer.Close())
 		}
-		if c.Conn != nil {
+		if p := c.tunnel.Load(); p != nil {
+			errs = append(errs, (*p).Close())
+		} else if c.Conn != nil {
 			errs = append(errs, c.Conn.Close())
 		}
+		if c.Rules != nil {
+			if m, ok := c.Rules.Mapping.(io.Closer); ok {
+				errs = append(errs, m.Close())
+			}
+		}
 		return
 	})
 }
@@ -114,6 +232,7 @@ func (c *Client) uplinkService() (_ error) {
 		ip = packet.Make(64, c.MaxRecvBuff)
 		s  = c.peer.Builtin().Reset(0, netip.IPv4Unspecified())
 	)
+	tunnel := func(ip *packet.Packet) error { return c.uplink(s, ip) }
 
 	for {
 		err := c.Capturer.Capture(ip.Sets(64, 0xffff))
@@ -131,82 +250,336 @@ func (c *Client) uplinkService() (_ error) {
 			}
 		}
 
-		hdr := header.IPv4(ip.Bytes())
-		s.Reset(hdr.TransportProtocol(), netip.AddrFrom4(hdr.DestinationAddress().As4()))
-
-		pkt := checksum.Client(ip)
-		if err = c.Conn.Send(s, pkt); err != nil {
+		if c.Rules != nil {
+			err = c.uplinkRule(ip, tunnel)
+		} else {
+			err = tunnel(ip)
+		}
+		if err != nil {
 			return c.close(err)
 		}
 	}
 }
 
+// uplink tunnel captured packet, hold it if reconnecting
+func (c *Client) uplink(s conn.Peer, ip *packet.Packet) error {
+	if c.Reconnect != nil && c.hold(ip) {
+		return nil
+	}
+	if err := c.send(s, ip); err != nil {
+		if c.failed(c.current(), err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// send tunnel captured packet
+func (c *Client) send(s conn.Peer, ip *packet.Packet) error {
+	sess, err := FromIP(ip.Bytes())
+	if err != nil {
+		c.Logger.Warn(err.Error(), errorx.Trace(err))
+		return nil
+	}
+	if sess.Dst.Addr().Is6() {
+		// server's sender is ipv4 only, builtin capturer not capture ipv6
+		if p, ok := c.Capturer.(Passer); ok {
+			return p.Pass(ip)
+		}
+		c.Logger.Debug("ipv6 flow can't be tunneled, server not support ipv6", slog.String("session", sess.String()))
+		return nil
+	} else if s.Reset(sess.Proto, sess.Dst.Addr()); !s.Valid() {
+		c.Logger.Warn("peer not support", slog.String("session", sess.String()))
+		return nil
+	}
+	c.setLocal(sess.Src.Addr())
+
+	return c.current().Send(s, checksum.Client(ip))
+}
+
 func (c *Client) downlinkServic() error {
 	var (
 		pkt  = packet.Make(0, c.MaxRecvBuff)
 		peer = c.peer.Builtin().Reset(0, netip.IPv4Unspecified())
+		tun  = c.current()
 	)
 
 	for {
-		err := c.Conn.Recv(peer, pkt.Sets(64, 0xffff))
+		err := tun.Recv(peer, pkt.Sets(64, 0xffff))
 		if err != nil {
 			if errorx.Temporary(err) {
 				c.Logger.Warn(err.Error(), errorx.Trace(err))
 				continue
+			} else if c.failed(tun, err) {
+				if tun, err = c.wait(); err != nil {
+					return c.close(err)
+				}
+				continue
 			} else {
 				return c.close(err)
 			}
 		}
 
-		ip := header.IPv4(pkt.AttachN(header.IPv4MinimumSize).Bytes())
-		ip.Encode(&header.IPv4Fields{
-			TotalLength: uint16(pkt.Data()),
-			TTL:         64,
-			Protocol:    uint8(peer.Protocol()),
-			SrcAddr:     tcpip.AddrFrom4(peer.Peer().As4()),
-			DstAddr:     tcpip.AddrFrom4(c.Conn.LocalAddr().Addr().As4()),
-		})
-		rechecksum(ip)
+		local, has := c.local(peer.Peer())
+		if !has {
+			c.Logger.Warn("not local address", slog.String("peer", peer.String()))
+			continue
+		}
+		if links.IsICMPError(peer.Protocol(), pkt.Bytes()) {
+			// quoted packet is sent by process, restore it's source address
+			if err := links.SetQuotedSrc(pkt.Bytes(), local); err != nil {
+				c.Logger.Warn(err.Error(), errorx.Trace(err))
+				continue
+			}
+		}
+		var ip []byte
+		if peer.Peer().Is4() {
+			ip = pkt.AttachN(header.IPv4MinimumSize).Bytes()
+			header.IPv4(ip).Encode(&header.IPv4Fields{
+				TotalLength: uint16(pkt.Data()),
+				TTL:         64,
+				Protocol:    uint8(peer.Protocol()),
+				SrcAddr:     tcpip.AddrFrom4(peer.Peer().As4()),
+				DstAddr:     tcpip.AddrFrom4(local.As4()),
+			})
+		} else {
+			n := pkt.Data()
+			ip = pkt.AttachN(header.IPv6MinimumSize).Bytes()
+			header.IPv6(ip).Encode(&header.IPv6Fields{
+				PayloadLength:     uint16(n),
+				TransportProtocol: peer.Protocol(),
+				HopLimit:          64,
+				SrcAddr:           tcpip.AddrFrom16(peer.Peer().As16()),
+				DstAddr:           tcpip.AddrFrom16(local.As16()),
+			})
+		}
+		if err := rechecksum(ip); err != nil {
+			c.Logger.Warn(err.Error(), errorx.Trace(err), slog.String("peer", peer.String()))
+			continue
+		}
 
 		if c.PcapCapturer != nil {
 			if err := c.PcapCapturer.WriteIP(ip); err != nil {
 				return c.close(err)
 			}
 		}
+		if c.Rules != nil {
+			s, err := FromIP(ip)
+			if err == nil {
+				out := Session{Src: s.Dst, Proto: s.Proto, Dst: s.Src}
+				if c.sessions.downlink(out, func(name string) (Action, string) { return c.Rules.decide(s, Inbound, name) }) == Drop {
+					continue
+				}
+			}
+			if err == nil && s.Proto == header.UDPProtocolNumber && s.Src.Port() == 53 {
+				// direct dns response snooped by dnsSnoopService
+				if err := c.Rules.DNS.Snoop(transportPayload(ip, s.Proto)); err != nil {
+					c.Logger.Warn(err.Error(), errorx.Trace(err))
+				}
+			}
+		}
 		if err = c.Capturer.Inject(pkt); err != nil {
 			return c.close(err)
 		}
 	}
 }
 
+// uplinkRule apply rules to captured packet, tunnel the packet if necessary
+func (c *Client) uplinkRule(ip *packet.Packet, tunnel func(ip *packet.Packet) error) error {
+	s, err := FromIP(ip.Bytes())
+	if err != nil {
+		return tunnel(ip)
+	}
+	if c.splice != nil {
+		if a, ok := c.splice.exempt(s); ok {
+			return c.apply(s, a, ip, tunnel)
+		} else if spliced, err := c.splice.intercept(ip, s); spliced || err != nil {
+			return err
+		}
+	}
+
+	a, hold, released := c.sessions.uplink(ip.Bytes(), s, c.Sniff, func(name string) (Action, string) {
+		return c.match(s, name)
+	})
+	if hold {
+		return nil
+	}
+	for _, b := range released {
+		if err := c.apply(s, a, packet.Make(64, 0, len(b)).Append(b...), tunnel); err != nil {
+			return err
+		}
+	}
+	return c.apply(s, a, ip, tunnel)
+}
+
+// match get captured flow's action and the matched domain
+func (c *Client) match(s Session, name string) (Action, string) {
+	return c.Rules.decide(s, Outbound, name)
+}
+
+func (c *Client) apply(s Session, a Action, ip *packet.Packet, tunnel func(ip *packet.Packet) error) error {
+	switch a {
+	case Drop:
+		return nil
+	case Direct:
+		p, ok := c.Capturer.(Passer)
+		if !ok {
+			c.Logger.Warn("capturer not support direct, tunnel it", slog.String("session", s.String()))
+			return tunnel(ip)
+		}
+		if err := p.Pass(ip); err != nil {
+			c.Logger.Warn(err.Error(), errorx.Trace(err))
+		}
+		return nil
+	default:
+		return tunnel(ip)
+	}
+}
+
+// sniffService release held packets of sniffing timeout flows
+func (c *Client) sniffService() {
+	var (
+		s      = c.peer.Builtin().Reset(0, netip.IPv4Unspecified())
+		ticker = time.NewTicker(sniffTimeout / 2)
+	)
+	defer ticker.Stop()
+	tunnel := func(ip *packet.Packet) error { return c.uplink(s, ip) }
+
+	for {
+		select {
+		case <-c.srvCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, h := range c.sessions.expired() {
+			for _, b := range h.ips {
+				if err := c.apply(h.sess, h.action, packet.Make(64, 0, len(b)).Append(b...), tunnel); err != nil {
+					c.close(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Sessions get captured flows, require Rules
+func (c *Client) Sessions() []SessionInfo {
+	if c.sessions == nil {
+		return nil
+	}
+	return c.sessions.list()
+}
+
 func (c *Client) Close() error { return c.close(nil) }
 
-func rechecksum(ip header.IPv4) {
-	ip.SetChecksum(0)
-	ip.SetChecksum(^ip.CalculateChecksum())
+// setLocal record captured packet's source address, as downlink packet's destination address
+func (c *Client) setLocal(addr netip.Addr) {
+	if addr.Is4() {
+		if p := c.local4.Load(); p == nil || *p != addr {
+			c.local4.Store(&addr)
+		}
+	} else {
+		if p := c.local6.Load(); p == nil || *p != addr {
+			c.local6.Store(&addr)
+		}
+	}
+}
+
+func (c *Client) local(peer netip.Addr) (netip.Addr, bool) {
+	var p *netip.Addr
+	if peer.Is4() {
+		p = c.local4.Load()
+	} else {
+		p = c.local6.Load()
+	}
+	if p == nil {
+		return netip.Addr{}, false
+	}
+	return *p, true
+}
+
+// rechecksum recalculate ip and transport checksum, the packet maybe sent by
+// server, so it's length be validated
+func rechecksum(ip []byte) error {
+	var hdr header.Network
+	switch ver := header.IPVersion(ip); ver {
+	case 4:
+		ip := header.IPv4(ip)
+		if !ip.IsValid(len(ip)) {
+			return errors.New("invalid ipv4 packet")
+		}
+		ip.SetChecksum(0)
+		ip.SetChecksum(^ip.CalculateChecksum())
+		hdr = ip
+	case 6:
+		ip := header.IPv6(ip)
+		if !ip.IsValid(len(ip)) {
+			return errors.New("invalid ipv6 packet")
+		}
+		hdr = ip
+	default:
+		return errors.Errorf("not support ip version %d", ver)
+	}
 
 	psum := header.PseudoHeaderChecksum(
-		ip.TransportProtocol(),
-		ip.SourceAddress(),
-		ip.DestinationAddress(),
-		ip.PayloadLength(),
+		hdr.TransportProtocol(),
+		hdr.SourceAddress(),
+		hdr.DestinationAddress(),
+		uint16(len(hdr.Payload())),
 	)
-	switch proto := ip.TransportProtocol(); proto {
+	switch proto := hdr.TransportProtocol(); proto {
 	case header.TCPProtocolNumber:
-		tcp := header.TCP(ip.Payload())
+		tcp := header.TCP(hdr.Payload())
+		if len(tcp) < header.TCPMinimumSize {
+			return errors.New("invalid tcp packet")
+		}
 		tcp.SetChecksum(0)
 		tcp.SetChecksum(^stdsum.Checksum(tcp, psum))
 	case header.UDPProtocolNumber:
-		udp := header.UDP(ip.Payload())
+		udp := header.UDP(hdr.Payload())
+		if len(udp) < header.UDPMinimumSize {
+			return errors.New("invalid udp packet")
+		}
 		udp.SetChecksum(0)
 		udp.SetChecksum(^stdsum.Checksum(udp, psum))
+	case header.ICMPv4ProtocolNumber:
+		icmp := header.ICMPv4(hdr.Payload())
+		if len(icmp) < header.ICMPv4MinimumSize {
+			return errors.New("invalid icmp packet")
+		}
+		icmp.SetChecksum(0)
+		icmp.SetChecksum(^stdsum.Checksum(icmp, 0))
+	case header.ICMPv6ProtocolNumber:
+		icmp := header.ICMPv6(hdr.Payload())
+		if len(icmp) < header.ICMPv6MinimumSize {
+			return errors.New("invalid icmpv6 packet")
+		}
+		icmp.SetChecksum(0)
+		icmp.SetChecksum(^stdsum.Checksum(icmp, psum))
 	default:
-		panic(fmt.Sprintf("not support protocol %d", proto))
+		if !links.IsGeneric(proto) {
+			return errors.Errorf("not support protocol %d", proto)
+		}
+		// generic ip protocol's checksum not include pseudo header
 	}
 
 	if debug.Debug() {
 		test.ValidIP(test.T(), ip)
 	}
+	return nil
+}
+
+// ipPayload get ip packet's payload, not include ipv6 extension header
+func ipPayload(ip []byte) []byte {
+	switch header.IPVersion(ip) {
+	case 4:
+		return header.IPv4(ip).Payload()
+	case 6:
+		return header.IPv6(ip).Payload()
+	default:
+		return nil
+	}
 }
 
 func UpdateTcpMssOption(hdr header.TCP, delta int) error {
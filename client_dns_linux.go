@@ -0,0 +1,100 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"os"
+	"unsafe"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// dnsSnoopService snoop dns response of direct flows, that not pass through the
+// client, by raw socket that receive copy of inbound udp packet with source port
+// 53. tunneled dns response also be received, that is harmless.
+func (c *Client) dnsSnoopService() {
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		f, err := dnsSnooper(family)
+		if err != nil {
+			c.Logger.Warn(err.Error(), errorx.Trace(err))
+			continue
+		}
+		go func() {
+			<-c.srvCtx.Done()
+			f.Close()
+		}()
+		go c.snoopService(f, family == unix.AF_INET)
+	}
+}
+
+func (c *Client) snoopService(f *os.File, ipv4 bool) {
+	var b = make([]byte, 0xffff)
+	for {
+		n, err := f.Read(b)
+		if err != nil {
+			if c.srvCtx.Err() == nil {
+				c.Logger.Warn(err.Error(), errorx.Trace(err))
+			}
+			return
+		}
+
+		udp := b[:n] // ipv6 raw socket not include ip header
+		if ipv4 {
+			if !header.IPv4(udp).IsValid(n) {
+				continue
+			}
+			udp = header.IPv4(udp).Payload()
+		}
+		if len(udp) < header.UDPMinimumSize {
+			continue
+		}
+		if err := c.Rules.DNS.Snoop(udp[header.UDPMinimumSize:]); err != nil {
+			c.Logger.Debug(err.Error(), errorx.Trace(err))
+		}
+	}
+}
+
+// dnsSnooper create raw udp socket, only accept packet that source port is 53
+func dnsSnooper(family int) (*os.File, error) {
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.IPPROTO_UDP)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var ins []bpf.Instruction
+	if family == unix.AF_INET {
+		ins = append(ins,
+			// store IPv4HdrLen regX
+			bpf.LoadMemShift{Off: 0},
+			bpf.LoadIndirect{Off: 0, Size: 2}, // udp source port
+		)
+	} else {
+		ins = append(ins, bpf.LoadAbsolute{Off: 0, Size: 2})
+	}
+	ins = append(ins,
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 53, SkipTrue: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	)
+	rawIns, err := bpf.Assemble(ins)
+	if err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	prog := &unix.SockFprog{
+		Len:    uint16(len(rawIns)),
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&rawIns[0])),
+	}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, prog); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	// nonblock fd be added to runtime poller, Close will unblock Read
+	return os.NewFile(uintptr(fd), "dns-snoop"), nil
+}
@@ -4,10 +4,44 @@
 package fatun
 
 import (
-	"errors"
 	"net/netip"
+	"syscall"
+
+	"github.com/lysShub/fatun/mapping"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 func NewDefaultCapture(laddr netip.AddrPort, overhead int) (Capturer, error) {
-	return nil, errors.New("linux not default capture")
+	return NewTunCapture(DefaultTunName, laddr, overhead)
+}
+
+func newProcessMapping() (ProcessMapping, error) {
+	return mapping.New()
+}
+
+// bindAny bind socket to wildcard address with ephemeral port before connect
+func bindAny(c syscall.RawConn, ipv6 bool) (port uint16, err error) {
+	cerr := c.Control(func(fd uintptr) {
+		var sa unix.Sockaddr = &unix.SockaddrInet4{}
+		if ipv6 {
+			sa = &unix.SockaddrInet6{}
+		}
+		if err = unix.Bind(int(fd), sa); err != nil {
+			return
+		}
+		if sa, err = unix.Getsockname(int(fd)); err != nil {
+			return
+		}
+		switch sa := sa.(type) {
+		case *unix.SockaddrInet4:
+			port = uint16(sa.Port)
+		case *unix.SockaddrInet6:
+			port = uint16(sa.Port)
+		}
+	})
+	if cerr != nil {
+		return 0, errors.WithStack(cerr)
+	}
+	return port, errors.WithStack(err)
 }
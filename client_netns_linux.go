@@ -0,0 +1,211 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/lysShub/fatun/conn"
+	"github.com/lysShub/fatun/netlink"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	netcall "github.com/lysShub/netkit/syscall"
+	"github.com/lysShub/netkit/tun"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// NetnsCapture capture all traffic of a new network namespace, the namespace
+// only has a tun device as ipv4 default route, the host's network not be changed.
+// ipv6 is unreachable in the namespace, because server's sender is ipv4 only.
+type NetnsCapture struct {
+	ns       *os.File // network namespace
+	tun      *tun.TunTap
+	overhead int
+
+	closeErr errorx.CloseErr
+}
+
+var _ Capturer = (*NetnsCapture)(nil)
+
+// netns tun device address if laddr is ipv6, the namespace is isolated, so
+// any address is ok.
+var netnsAddr4 = netip.MustParseAddr("10.0.0.1")
+
+// NewNetnsCapture, laddr is the client udp connect local address, it will be
+// set as tun device address in namespace, so injected packet not need change address.
+func NewNetnsCapture(laddr netip.AddrPort, overhead int) (*NetnsCapture, error) {
+	var c = &NetnsCapture{overhead: overhead}
+	addr4 := netnsAddr4
+	if laddr.Addr().Is4() {
+		addr4 = laddr.Addr()
+	}
+
+	err := lockThread(func() error {
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			return errors.WithStack(err)
+		}
+		ns, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		c.ns = ns
+
+		if err := netcall.IoctlAifflags("lo", unix.IFF_UP|unix.IFF_RUNNING); err != nil {
+			return err
+		}
+		if c.tun, err = tun.Tun(DefaultTunName); err != nil {
+			return err
+		}
+		if err := c.tun.SetAddr(netip.PrefixFrom(addr4, 32)); err != nil {
+			return err
+		}
+		ifi, err := net.InterfaceByName(DefaultTunName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return netlink.AddRoute(netlink.Route{
+			Dest:      netip.PrefixFrom(netip.IPv4Unspecified(), 0),
+			Interface: uint32(ifi.Index),
+		})
+	})
+	if err != nil {
+		return nil, c.close(err)
+	}
+	return c, nil
+}
+
+// lockThread exec fn on a locked os thread, and restore network namespace after fn return
+func lockThread(fn func() error) (err error) {
+	runtime.LockOSThread()
+	orig, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return errors.WithStack(err)
+	}
+	defer orig.Close()
+
+	err = fn()
+	if e := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); e != nil {
+		// keep thread locked, the thread will be terminated when goroutine exit
+		if err == nil {
+			err = errors.WithStack(e)
+		}
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
+
+// Do exec fn in the network namespace
+func (c *NetnsCapture) Do(fn func() error) error {
+	return lockThread(func() error {
+		if err := unix.Setns(int(c.ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			return errors.WithStack(err)
+		}
+		return fn()
+	})
+}
+
+// Exec start cmd in the network namespace, and wait it exit, the capture
+// will be closed after cmd exited.
+func (c *NetnsCapture) Exec(cmd *exec.Cmd) error {
+	if err := c.Do(cmd.Start); err != nil {
+		return c.close(err)
+	}
+	err := cmd.Wait()
+	if e := c.close(nil); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *NetnsCapture) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		if c.tun != nil {
+			errs = append(errs, c.tun.Close())
+		}
+		if c.ns != nil {
+			errs = append(errs, c.ns.Close())
+		}
+		return errs
+	})
+}
+
+func (c *NetnsCapture) Capture(ip *packet.Packet) error {
+	head, data := ip.Head(), ip.Data()
+	for {
+		n, err := c.tun.Read(context.Background(), ip.Sets(head, data).Bytes())
+		if err != nil {
+			return c.close(err)
+		}
+		ip.SetData(n)
+
+		s, err := FromIP(ip.Bytes())
+		if err != nil || s.Dst.Addr().IsMulticast() || s.Dst.Addr().IsLinkLocalUnicast() {
+			continue
+		}
+
+		if s.Proto == header.TCPProtocolNumber {
+			UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
+		}
+		return nil
+	}
+}
+
+func (c *NetnsCapture) Inject(ip *packet.Packet) error {
+	if s, err := FromIP(ip.Bytes()); err == nil && s.Proto == header.TCPProtocolNumber {
+		UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
+	}
+
+	_, err := c.tun.Write(context.Background(), ip.Bytes())
+	if err != nil {
+		return c.close(errors.WithStack(err))
+	}
+	return nil
+}
+
+func (c *NetnsCapture) Close() error { return c.close(nil) }
+
+// Exec run cmd inside the tunnel, create Client by opts (see NewClient) that
+// capture a new network namespace by NetnsCapture, cmd be run in the namespace,
+// the client will be closed after cmd exited.
+func Exec[P conn.Peer](cmd *exec.Cmd, opts ...func(*Client)) error {
+	c, err := NewClient[P](append(opts, func(c *Client) {
+		c.newCapture = func(laddr netip.AddrPort, overhead int) (Capturer, error) {
+			ns, err := NewNetnsCapture(laddr, overhead)
+			if err != nil {
+				return nil, err
+			}
+			return ns, nil
+		}
+	})...)
+	if err != nil {
+		return err
+	}
+	return c.Exec(cmd)
+}
+
+// Exec run cmd in a tunneled network namespace, require Client's Capturer is
+// NetnsCapture, the client will be closed after cmd exited. see Exec.
+func (c *Client) Exec(cmd *exec.Cmd) error {
+	ns, ok := c.Capturer.(*NetnsCapture)
+	if !ok {
+		return errors.Errorf("exec require NetnsCapture, not %T", c.Capturer)
+	}
+
+	c.Run()
+	err := ns.Exec(cmd)
+	if e := c.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
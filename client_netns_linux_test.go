@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_NetnsCapture(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("require root")
+	}
+	var (
+		laddr = netip.MustParseAddrPort("10.0.0.2:19986")
+		dst   = netip.MustParseAddrPort("8.8.8.8:53")
+	)
+	c, err := NewNetnsCapture(laddr, 0)
+	require.NoError(t, err)
+	defer c.Close()
+
+	t.Run("capture", func(t *testing.T) {
+		err = c.Do(func() error {
+			conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(dst))
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = conn.Write([]byte("hello"))
+			return err
+		})
+		require.NoError(t, err)
+
+		var ip = packet.Make(64, 1536)
+		require.NoError(t, c.Capture(ip))
+		s, err := FromIP(ip.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, laddr.Addr(), s.Src.Addr())
+		require.Equal(t, dst, s.Dst)
+		require.Equal(t, header.UDPProtocolNumber, s.Proto)
+	})
+
+	t.Run("exec", func(t *testing.T) {
+		out, err := os.CreateTemp(t.TempDir(), "")
+		require.NoError(t, err)
+		defer out.Close()
+
+		cmd := exec.Command("cat", "/proc/net/dev")
+		cmd.Stdout = out
+		require.NoError(t, c.Exec(cmd))
+
+		b, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		require.Contains(t, string(b), DefaultTunName)
+	})
+}
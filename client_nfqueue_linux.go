@@ -0,0 +1,280 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/fatun/mapping"
+	"github.com/lysShub/fatun/netlink"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/netkit/tun"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	DefaultQueueNum   = 19986
+	DefaultInjectName = "fatun-inject"
+
+	nfqChain = "FATUN"
+)
+
+// NfqCapture capture outbound ip packet by netfilter queue, similar to divert on
+// windows, packet not be captured will be accepted, the captured packet be stolen,
+// downlink packet be injected to inbound path by a tun device without route.
+// only ipv4 traffic be queued.
+type NfqCapture struct {
+	// QueueNum netfilter queue number
+	QueueNum uint16
+
+	// InjectName the tun device name, that use for inject inbound packet
+	InjectName string
+
+	// SkipIptables not install iptables rules, user should queue outbound
+	// packets to QueueNum self.
+	SkipIptables bool
+
+	laddr    netip.AddrPort
+	overhead int
+	filter   atomic.Pointer[Filter]
+	flows    *flows
+	mapping  *mapping.Mapping // process mapping, see Enable
+
+	queue       *netlink.Queue
+	inject      *tun.TunTap
+	chain, jump bool // iptables
+
+	closeErr errorx.CloseErr
+}
+
+var _ Capturer = (*NfqCapture)(nil)
+var _ Filterer = (*NfqCapture)(nil)
+
+// NewNfqCapture, laddr is the client udp connect local address, that will never be captured.
+func NewNfqCapture(laddr netip.AddrPort, overhead int, opts ...func(*NfqCapture)) (*NfqCapture, error) {
+	if !laddr.Addr().Is4() {
+		return nil, errors.Errorf("only support ipv4 %s", laddr.String())
+	}
+	var c = &NfqCapture{
+		QueueNum:   DefaultQueueNum,
+		InjectName: DefaultInjectName,
+		laddr:      laddr,
+		overhead:   overhead,
+		flows:      newFlows(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	var err error
+
+	if c.inject, err = tun.Tun(c.InjectName); err != nil {
+		return nil, c.close(err)
+	}
+	// injected packet's source address not route by inject device, use loose mode
+	if err := sysctl(fmt.Sprintf("net/ipv4/conf/%s/rp_filter", c.InjectName), "2"); err != nil {
+		return nil, c.close(err)
+	}
+
+	if c.queue, err = netlink.OpenQueue(c.QueueNum, unix.AF_INET); err != nil {
+		return nil, c.close(err)
+	}
+
+	if !c.SkipIptables {
+		if err := c.setIptables(); err != nil {
+			return nil, c.close(err)
+		}
+	}
+	return c, nil
+}
+
+func (c *NfqCapture) setIptables() error {
+	if err := iptables("-N", nfqChain); err != nil {
+		return err
+	}
+	c.chain = true
+
+	queue := []string{"-j", "NFQUEUE", "--queue-num", strconv.Itoa(int(c.QueueNum)), "--queue-bypass"}
+	for _, r := range [][]string{
+		{"-o", "lo", "-j", "RETURN"},
+		{"-p", "udp", "--sport", strconv.Itoa(int(c.laddr.Port())), "-j", "RETURN"},
+		append([]string{"-p", "tcp"}, queue...),
+		append([]string{"-p", "udp"}, queue...),
+		append([]string{"-p", "icmp", "--icmp-type", "echo-request"}, queue...),
+	} {
+		if err := iptables(append([]string{"-A", nfqChain}, r...)...); err != nil {
+			return err
+		}
+	}
+
+	if err := iptables("-A", "OUTPUT", "-j", nfqChain); err != nil {
+		return err
+	}
+	c.jump = true
+	return nil
+}
+
+func (c *NfqCapture) delIptables() (errs []error) {
+	if c.jump {
+		errs = append(errs, iptables("-D", "OUTPUT", "-j", nfqChain))
+	}
+	if c.chain {
+		errs = append(errs, iptables("-F", nfqChain))
+		errs = append(errs, iptables("-X", nfqChain))
+	}
+	return errs
+}
+
+func (c *NfqCapture) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		errs = append(errs, c.delIptables()...)
+		if c.queue != nil {
+			errs = append(errs, c.queue.Close())
+		}
+		if c.inject != nil {
+			errs = append(errs, c.inject.Close())
+		}
+		if c.mapping != nil {
+			errs = append(errs, c.mapping.Close())
+		}
+		return errs
+	})
+}
+
+// SetFilter set capture flow filter, nil means capture all flows
+func (c *NfqCapture) SetFilter(f Filter) {
+	c.filter.Store(&f)
+	c.flows.reset()
+}
+
+func (c *NfqCapture) capture(s Session) bool {
+	if s.Dst.Addr().IsMulticast() || s.Src == c.laddr {
+		return false
+	}
+	if f := c.filter.Load(); f != nil && *f != nil {
+		return (*f)(s)
+	}
+	return true
+}
+
+func (c *NfqCapture) Capture(ip *packet.Packet) error {
+	head, data := ip.Head(), ip.Data()
+	for {
+		id, n, err := c.queue.Read(ip.Sets(head, data).Bytes())
+		if err != nil {
+			if errors.Is(err, unix.EMSGSIZE) {
+				if err := c.queue.Verdict(id, netlink.NfAccept); err != nil {
+					return c.close(err)
+				}
+				return errorx.WrapTemp(err)
+			} else if errors.Is(err, unix.ENOBUFS) {
+				return errorx.WrapTemp(err) // queue overrun, packets be dropped by kernel
+			}
+			return c.close(err)
+		}
+		ip.SetData(n)
+
+		s, err := FromIP(ip.Bytes())
+		capture := err == nil && c.flows.capture(s, c.capture)
+		if !capture {
+			if err := c.queue.Verdict(id, netlink.NfAccept); err != nil {
+				return c.close(err)
+			}
+			continue
+		}
+		if err := c.queue.Verdict(id, netlink.NfDrop); err != nil {
+			return c.close(err)
+		}
+
+		if s.Proto == header.TCPProtocolNumber {
+			UpdateTcpMssOption(header.IPv4(ip.Bytes()).Payload(), -c.overhead)
+		}
+		return nil
+	}
+}
+
+func (c *NfqCapture) Inject(ip *packet.Packet) error {
+	if s, err := FromIP(ip.Bytes()); err == nil && s.Proto == header.TCPProtocolNumber {
+		UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
+	}
+
+	_, err := c.inject.Write(context.Background(), ip.Bytes())
+	if err != nil {
+		return c.close(errors.WithStack(err))
+	}
+	return nil
+}
+
+func (c *NfqCapture) Close() error { return c.close(nil) }
+
+// flows cache per-flow verdict, avoid call filter for every packet
+type flows struct {
+	mu      sync.Mutex
+	flows   map[Session]*flow
+	cleaned time.Time
+}
+
+type flow struct {
+	capture bool
+	last    time.Time
+}
+
+const flowIdle = time.Minute * 2
+
+func newFlows() *flows {
+	return &flows{flows: map[Session]*flow{}, cleaned: time.Now()}
+}
+
+func (f *flows) capture(s Session, filter func(Session) bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(f.cleaned) > flowIdle {
+		for k, v := range f.flows {
+			if now.Sub(v.last) > flowIdle {
+				delete(f.flows, k)
+			}
+		}
+		f.cleaned = now
+	}
+
+	e, has := f.flows[s]
+	if !has {
+		e = &flow{capture: filter(s)}
+		f.flows[s] = e
+	}
+	e.last = now
+	return e.capture
+}
+
+func (f *flows) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.flows)
+}
+
+func iptables(args ...string) error {
+	out, err := exec.Command("iptables", append([]string{"-t", "mangle"}, args...)...).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("iptables %v: %s %s", args, err.Error(), string(out))
+	}
+	return nil
+}
+
+func sysctl(key, val string) error {
+	err := os.WriteFile("/proc/sys/"+key, []byte(val), 0644)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Flows_Verdict(t *testing.T) {
+	var (
+		f = newFlows()
+		s = Session{
+			Src:   netip.MustParseAddrPort("10.0.0.2:1234"),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.MustParseAddrPort("8.8.8.8:80"),
+		}
+		calls int
+	)
+	filter := func(Session) bool { calls++; return true }
+
+	require.True(t, f.capture(s, filter))
+	require.True(t, f.capture(s, filter))
+	require.Equal(t, 1, calls)
+
+	f.reset()
+	require.False(t, f.capture(s, func(Session) bool { return false }))
+}
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"github.com/lysShub/fatun/mapping"
+)
+
+// Process select process by name, pid or executable path, zero field not match,
+// the selected process's child processes also be selected.
+type Process struct {
+	Name string // as /proc/[pid]/comm
+	Pid  uint32
+	Exe  string // executable path
+}
+
+func (p Process) match(pid uint32) bool {
+	if p.Pid != 0 && p.Pid == pid {
+		return true
+	}
+	if p.Name != "" {
+		if name, err := mapping.Comm(pid); err == nil && name == p.Name {
+			return true
+		}
+	}
+	if p.Exe != "" {
+		if exe, err := mapping.Exe(pid); err == nil && exe == p.Exe {
+			return true
+		}
+	}
+	return false
+}
+
+const maxProcessDepth = 64
+
+// NewProcessFilter flows filter, select flows that belong to the processes or their child processes
+func NewProcessFilter(m *mapping.Mapping, ps ...Process) Filter {
+	return func(s Session) (capture bool) {
+		pid, err := m.Pid(s.Src, uint8(s.Proto))
+		if err != nil {
+			return false // todo: logger if not errorx.Temporary(err)
+		}
+
+		for i := 0; i < maxProcessDepth && pid > 1; i++ {
+			for _, p := range ps {
+				if p.match(pid) {
+					return true
+				}
+			}
+			if pid, err = mapping.Ppid(pid); err != nil {
+				return false
+			}
+		}
+		return false
+	}
+}
+
+// Enable only capture the processes's flows, not call Enable will capture all flows
+func (c *NfqCapture) Enable(ps ...Process) error {
+	if c.mapping == nil {
+		var err error
+		if c.mapping, err = mapping.New(); err != nil {
+			return err
+		}
+	}
+	c.SetFilter(NewProcessFilter(c.mapping, ps...))
+	return nil
+}
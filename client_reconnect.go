@@ -0,0 +1,180 @@
+package fatun
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/fatun/conn"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+)
+
+// Reconnect supervised reconnect, re-establish the tunnel conn with exponential
+// backoff if it failed, instead of close the client. server resume the links of
+// reconnected client only if it resumed the previous session, so long-lived
+// flows survive short outages, that require both sides enable conn.Resumption
+// with TLS or Noise.
+type Reconnect struct {
+	// Dial establish new tunnel conn, laddr is the failed tunnel conn's local
+	// address, that maybe changed by roaming, should bind it's port, because
+	// Capturer skip tunnel traffic by it, the Capturer will be rebound if the
+	// new conn's local address is different. default dial DefaultPort of localhost.
+	Dial func(ctx context.Context, laddr netip.AddrPort) (conn.Conn, error)
+
+	// MinBackoff first retry delay, doubled every failed retry, default 500ms
+	MinBackoff time.Duration
+	// MaxBackoff default 30s
+	MaxBackoff time.Duration
+
+	// Buffer max captured packets buffered while tunnel down, sent after
+	// reconnected, the oldest be dropped if full. 0 means drop all.
+	Buffer int
+}
+
+func (r *Reconnect) minBackoff() time.Duration {
+	if r.MinBackoff <= 0 {
+		return time.Millisecond * 500
+	}
+	return r.MinBackoff
+}
+func (r *Reconnect) maxBackoff() time.Duration {
+	if r.MaxBackoff <= 0 {
+		return time.Second * 30
+	}
+	return r.MaxBackoff
+}
+
+func (c *Client) current() conn.Conn { return *c.tunnel.Load() }
+
+// failed handle tunnel conn failed, return false if client should be closed
+func (c *Client) failed(failed conn.Conn, cause error) bool {
+	if c.Reconnect == nil || c.closeErr.Closed() {
+		return false
+	}
+
+	c.downMu.Lock()
+	if c.current() != failed || c.down.Load() {
+		c.downMu.Unlock()
+		return true // reconnecting or reconnected
+	}
+	c.down.Store(true)
+	c.ready = make(chan struct{})
+	c.downMu.Unlock()
+
+	c.Logger.Warn("tunnel down, reconnecting", errorx.Trace(cause))
+	failed.Close()
+	go c.reconnectService()
+	return true
+}
+
+func (c *Client) reconnectService() {
+	var (
+		backoff = c.Reconnect.minBackoff()
+		laddr   = c.current().LocalAddr()
+	)
+	for {
+		select {
+		case <-c.srvCtx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		conn, err := c.Reconnect.Dial(c.srvCtx, laddr)
+		if err == nil {
+			if _, err = conn.BuiltinConn(c.srvCtx); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			c.Logger.Warn(err.Error(), errorx.Trace(err), slog.Duration("backoff", backoff))
+			backoff = min(backoff*2, c.Reconnect.maxBackoff())
+			continue
+		}
+
+		c.tunnel.Store(&conn)
+		if conn.LocalAddr() != laddr {
+			if err := c.rebind(conn.LocalAddr()); err != nil {
+				c.Logger.Error(err.Error(), errorx.Trace(err))
+			}
+		}
+		close(c.ready)
+		c.Logger.Info("tunnel reconnected", slog.String("server", conn.RemoteAddr().String()),
+			slog.Uint64("dropped", c.dropped.Swap(0)))
+		c.flush()
+		return
+	}
+}
+
+// rebind capturer after tunnel conn's local address changed, the capturer skip
+// tunnel traffic by it
+func (c *Client) rebind(laddr netip.AddrPort) error {
+	if r, ok := c.Capturer.(interface{ Rebind(netip.AddrPort) error }); ok {
+		return r.Rebind(laddr)
+	}
+	return nil
+}
+
+// wait the tunnel conn reconnected
+func (c *Client) wait() (conn.Conn, error) {
+	c.downMu.Lock()
+	ready := c.ready
+	c.downMu.Unlock()
+
+	select {
+	case <-ready:
+		return c.current(), nil
+	case <-c.srvCtx.Done():
+		return nil, c.srvCtx.Err()
+	}
+}
+
+// hold buffer captured packet while tunnel down, return false if tunnel is up
+func (c *Client) hold(ip *packet.Packet) bool {
+	if !c.down.Load() {
+		return false
+	}
+
+	c.downMu.Lock()
+	defer c.downMu.Unlock()
+	if !c.down.Load() {
+		return false
+	}
+	if c.Reconnect.Buffer <= 0 {
+		c.dropped.Add(1)
+		return true
+	}
+	if len(c.buffered) >= c.Reconnect.Buffer {
+		c.buffered = c.buffered[1:]
+		c.dropped.Add(1)
+	}
+	c.buffered = append(c.buffered, packet.Make(64, 0, ip.Data()).Append(ip.Bytes()...))
+	return true
+}
+
+// flush send buffered packets, then tunnel is up
+func (c *Client) flush() {
+	var peer = c.peer.Builtin().Reset(0, netip.IPv4Unspecified())
+	for {
+		c.downMu.Lock()
+		if len(c.buffered) == 0 {
+			c.down.Store(false)
+			c.downMu.Unlock()
+			return
+		}
+		ip := c.buffered[0]
+		c.buffered = c.buffered[1:]
+		c.downMu.Unlock()
+
+		if err := c.send(peer, ip); err != nil {
+			c.downMu.Lock()
+			c.down.Store(false) // keep remained buffered, flush after next reconnected
+			c.downMu.Unlock()
+			if !c.failed(c.current(), err) {
+				c.close(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package fatun
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lysShub/fatun/conn"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type mockConn struct {
+	conn.Conn
+	laddr  netip.AddrPort
+	mu     sync.Mutex
+	sent   int
+	closed bool
+}
+
+func (m *mockConn) BuiltinConn(context.Context) (net.Conn, error) { return nil, nil }
+func (m *mockConn) RemoteAddr() netip.AddrPort {
+	return netip.AddrPortFrom(netip.IPv4Unspecified(), DefaultPort)
+}
+func (m *mockConn) LocalAddr() netip.AddrPort { return m.laddr }
+func (m *mockConn) Send(conn.Peer, *packet.Packet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent++
+	return nil
+}
+func (m *mockConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func udpIP(t *testing.T, srcPort uint16) *packet.Packet {
+	var b = make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4([4]byte{10, 0, 0, 1}),
+		DstAddr:     tcpip.AddrFrom4([4]byte{8, 8, 8, 8}),
+	})
+	header.UDP(b[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+		SrcPort: srcPort, DstPort: 53, Length: header.UDPMinimumSize,
+	})
+	require.NoError(t, rechecksum(b))
+	return packet.Make(64, 0, len(b)).Append(b...)
+}
+
+func Test_Client_Reconnect(t *testing.T) {
+	var (
+		laddr    = netip.MustParseAddrPort("10.0.0.2:19986") // roamed
+		old, new = &mockConn{laddr: laddr}, &mockConn{laddr: laddr}
+		dials    = 0
+		dialed   netip.AddrPort
+		c        = &Client{
+			Logger: slog.Default(),
+			peer:   conn.NewDefaultPeer(),
+			ready:  make(chan struct{}),
+			Reconnect: &Reconnect{
+				MinBackoff: time.Millisecond * 10,
+				Buffer:     2,
+			},
+		}
+	)
+	c.Reconnect.Dial = func(ctx context.Context, local netip.AddrPort) (conn.Conn, error) {
+		dialed = local
+		if dials++; dials == 1 {
+			return nil, errors.New("server unreachable")
+		}
+		return new, nil
+	}
+	c.srvCtx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+	var cur conn.Conn = old
+	c.tunnel.Store(&cur)
+
+	require.False(t, c.hold(udpIP(t, 1)))
+
+	require.True(t, c.failed(old, errors.New("timeout")))
+	require.True(t, old.closed)
+	require.True(t, c.failed(old, errors.New("timeout")), "reconnecting")
+
+	// buffered packets over limit, the oldest be dropped
+	for i := uint16(1); i <= 3; i++ {
+		require.True(t, c.hold(udpIP(t, i)))
+	}
+
+	tun, err := c.wait()
+	require.NoError(t, err)
+	require.Same(t, new, tun)
+	require.Eventually(t, func() bool { return !c.down.Load() }, time.Second, time.Millisecond*10)
+	require.Equal(t, 2, dials)
+	require.Equal(t, laddr, dialed, "dial with current local address")
+	require.Equal(t, 2, new.sent)
+	require.Empty(t, c.buffered)
+	require.False(t, c.hold(udpIP(t, 4)))
+}
@@ -0,0 +1,100 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/fatun/netlink"
+	"github.com/lysShub/netkit/errorx"
+	"golang.org/x/sys/unix"
+)
+
+// roamDelay wait network changes settle, events usually be notified in burst
+const roamDelay = time.Second
+
+// roamService watch interface, address and default route changes, rebind the
+// tunnel socket and capturer to the new interface without restart client.
+func (c *Client) roamService() {
+	w, err := netlink.Watch()
+	if err != nil {
+		c.Logger.Warn(err.Error(), errorx.Trace(err))
+		return
+	}
+	go func() {
+		<-c.srvCtx.Done()
+		w.Close()
+	}()
+
+	var tunIdx uint32 // capturer's tun device
+	if t, ok := c.Capturer.(*TunCapture); ok {
+		tunIdx = uint32(t.ifi.Index)
+	}
+	timer := time.AfterFunc(roamDelay, c.roam)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		e, err := w.Next()
+		if err != nil {
+			if c.srvCtx.Err() == nil {
+				c.Logger.Warn(err.Error(), errorx.Trace(err))
+			}
+			return
+		}
+		if (tunIdx != 0 && e.Interface == tunIdx) ||
+			(e.Type == netlink.RouteAdded || e.Type == netlink.RouteRemoved) && !e.Default() {
+			continue
+		}
+
+		c.Logger.Info("network changed", slog.String("event", e.String()))
+		timer.Reset(roamDelay)
+	}
+}
+
+// roam rebind if source address of the tunnel socket changed
+func (c *Client) roam() {
+	tun := c.current()
+	laddr, raddr := tun.LocalAddr(), tun.RemoteAddr()
+	src, ifindex, err := netlink.RouteGet(raddr.Addr(), unix.IPPROTO_UDP, laddr.Port())
+	if err != nil {
+		// network unavailable, wait next change
+		c.Logger.Warn(err.Error(), errorx.Trace(err))
+		return
+	} else if src == laddr.Addr() || src.IsLoopback() {
+		if c.stale.Load() {
+			c.rebindCapture(laddr)
+		}
+		return
+	}
+
+	r, ok := tun.(interface{ Rebind() error })
+	if !ok {
+		c.Logger.Warn("tunnel conn not support rebind", slog.String("local", laddr.String()))
+		return
+	}
+	if err := r.Rebind(); err != nil {
+		c.Logger.Warn(err.Error(), errorx.Trace(err))
+		return
+	}
+	c.Logger.Info("client roamed",
+		slog.String("from", laddr.String()), slog.String("to", tun.LocalAddr().String()),
+		slog.Uint64("interface", uint64(ifindex)))
+	c.rebindCapture(tun.LocalAddr())
+}
+
+// rebindCapture rebind capturer to the roamed tunnel socket, if failed, capturer
+// still on old interface, retry at next network change
+func (c *Client) rebindCapture(laddr netip.AddrPort) {
+	if err := c.rebind(laddr); err != nil {
+		c.stale.Store(true)
+		c.Logger.Error(err.Error(), errorx.Trace(err))
+		return
+	}
+	if c.stale.Swap(false) {
+		c.Logger.Info("capturer rebound", slog.String("local", laddr.String()))
+	}
+}
@@ -0,0 +1,324 @@
+package fatun
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// splicer terminate new tcp flow by local listener, so the flow's server name can be
+// sniffed from first payload before connect to server, then connect to server by the
+// decided action, and splice the two connects.
+//
+// the flow be NATed between process and listener, uplink A:a->S:s be injected as
+// S:a->A:L, listener's reply A:L->S:a be injected as S:s->A:a. the connect to server
+// dialed by client be captured again, it's action registered by local port, so
+// require capturer can pass or filter the client's flow, see Passer and Filterer.
+type splicer struct {
+	client   *Client
+	listener *net.TCPListener
+	port     uint16 // listener port
+
+	mu      sync.Mutex
+	flows   map[Session]*splice // by process outbound session
+	peers   map[Session]*splice // by listener outbound session
+	exempts map[uint16]*splice  // by dialed connect's local port
+	cleaned time.Time
+}
+
+type splice struct {
+	proc, lis Session
+	port      uint16 // dialed connect's local port
+
+	name   string
+	action Action
+	conns  []*net.TCPConn // accepted and dialed connect
+	active bool
+	last   time.Time
+}
+
+func newSplicer(c *Client) (*splicer, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &splicer{
+		client:   c,
+		listener: l,
+		port:     uint16(l.Addr().(*net.TCPAddr).Port),
+		flows:    map[Session]*splice{},
+		peers:    map[Session]*splice{},
+		exempts:  map[uint16]*splice{},
+		cleaned:  time.Now(),
+	}, nil
+}
+
+// exempt get the action of client dialed connect's packet
+func (s *splicer) exempt(sess Session) (Action, bool) {
+	if sess.Proto != header.TCPProtocolNumber {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, has := s.exempts[sess.Src.Port()]
+	if !has {
+		return 0, false
+	}
+	return e.action, true
+}
+
+// intercept NAT the captured packet of new tcp flow or spliced flow, and inject it
+// to local listener or process, return false if the packet not be intercepted.
+func (s *splicer) intercept(ip *packet.Packet, sess Session) (bool, error) {
+	if sess.Proto != header.TCPProtocolNumber {
+		return false, nil
+	}
+
+	var src, dst netip.AddrPort
+	s.mu.Lock()
+	now := time.Now()
+	s.cleanup(now)
+	if e, has := s.peers[sess]; has {
+		e.last = now
+		src, dst = e.proc.Dst, e.proc.Src
+	} else if e, has := s.flows[sess]; has {
+		e.last = now
+		src, dst = e.lis.Dst, e.lis.Src
+	} else if tcp := header.TCP(ipPayload(ip.Bytes())); len(tcp) >= header.TCPMinimumSize && tcp.Flags() == header.TCPFlagSyn {
+		lis := Session{
+			Src:   netip.AddrPortFrom(sess.Src.Addr(), s.port),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.AddrPortFrom(sess.Dst.Addr(), sess.Src.Port()),
+		}
+		if _, has := s.peers[lis]; has {
+			s.mu.Unlock()
+			return false, nil // conflict with other flow, not sniff it
+		}
+		e := &splice{proc: sess, lis: lis, last: now}
+		s.flows[sess], s.peers[lis] = e, e
+		src, dst = lis.Dst, lis.Src
+	} else {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.mu.Unlock()
+
+	if err := nat(ip.Bytes(), src, dst); err != nil {
+		s.client.Logger.Warn(err.Error(), errorx.Trace(err))
+		return true, nil
+	}
+	return true, s.client.Capturer.Inject(ip)
+}
+
+// cleanup delete idle flows after spliced, require lock
+func (s *splicer) cleanup(now time.Time) {
+	if now.Sub(s.cleaned) < sessionIdle {
+		return
+	}
+	for k, e := range s.flows {
+		if !e.active && now.Sub(e.last) > sessionIdle {
+			delete(s.flows, k)
+			delete(s.peers, e.lis)
+			if s.exempts[e.port] == e {
+				delete(s.exempts, e.port)
+			}
+		}
+	}
+	s.cleaned = now
+}
+
+func (s *splicer) serve() {
+	for {
+		conn, err := s.listener.AcceptTCP()
+		if err != nil {
+			if s.client.srvCtx.Err() == nil {
+				s.client.Logger.Warn(err.Error(), errorx.Trace(err))
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *splicer) handle(conn *net.TCPConn) {
+	lis := Session{
+		Src:   netip.MustParseAddrPort(conn.LocalAddr().String()),
+		Proto: header.TCPProtocolNumber,
+		Dst:   netip.MustParseAddrPort(conn.RemoteAddr().String()),
+	}
+	s.mu.Lock()
+	e, has := s.peers[lis]
+	if has && !e.active {
+		e.active, e.conns = true, []*net.TCPConn{conn}
+	} else {
+		has = false
+	}
+	s.mu.Unlock()
+	if !has {
+		conn.Close()
+		return
+	}
+	defer s.done(e)
+
+	// wait first payload, flow be decided without name after timeout, e.g.
+	// server speak first protocol
+	var payloads [][]byte
+	var name string
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	for len(payloads) < maxHeld {
+		var b = make([]byte, 2048)
+		n, err := conn.Read(b)
+		if n > 0 {
+			payloads = append(payloads, b[:n])
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		} else if err != nil {
+			return
+		}
+
+		name, err = Sniff(header.TCPProtocolNumber, payloads...)
+		if !errors.Is(err, ErrSniffMore) {
+			if err != nil {
+				name = ""
+			}
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	a := s.client.sessions.spliced(e.proc, name, func(name string) (Action, string) {
+		return s.client.match(e.proc, name)
+	})
+	s.mu.Lock()
+	e.name, e.action = name, a
+	s.mu.Unlock()
+	if a == Drop || (a == Tunnel && e.proc.Dst.Addr().Is6()) {
+		conn.SetLinger(0) // ipv6 flow can't be tunneled, see Client.send
+		return
+	}
+
+	srv, err := s.dial(e)
+	if err != nil {
+		s.client.Logger.Warn(err.Error(), errorx.Trace(err), slog.String("session", e.proc.String()))
+		conn.SetLinger(0)
+		return
+	}
+	for _, b := range payloads {
+		if _, err := srv.Write(b); err != nil {
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(srv, conn)
+		srv.CloseWrite()
+	}()
+	io.Copy(conn, srv)
+	conn.CloseWrite()
+	wg.Wait()
+}
+
+// dial connect to the flow's server, register the connect's local port before
+// connect, so it's packets be applied the flow's action
+func (s *splicer) dial(e *splice) (*net.TCPConn, error) {
+	network := "tcp4"
+	if e.proc.Dst.Addr().Is6() {
+		network = "tcp6"
+	}
+	var d = net.Dialer{
+		Timeout: time.Second * 10,
+		Control: func(network, address string, c syscall.RawConn) error {
+			port, err := bindAny(c, e.proc.Dst.Addr().Is6())
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			e.port, s.exempts[port] = port, e
+			s.mu.Unlock()
+			return nil
+		},
+	}
+	conn, err := d.DialContext(s.client.srvCtx, network, e.proc.Dst.String())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !e.active {
+		conn.Close()
+		return nil, errors.WithStack(net.ErrClosed)
+	}
+	e.conns = append(e.conns, conn.(*net.TCPConn))
+	return conn.(*net.TCPConn), nil
+}
+
+// done close the spliced connects, the flow be deleted after idle
+func (s *splicer) done(e *splice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range e.conns {
+		c.Close()
+	}
+	e.active, e.conns, e.last = false, nil, time.Now()
+}
+
+// rematch rules replaced, reset spliced flows that action changed, the process
+// will reconnect by new action
+func (s *splicer) rematch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.flows {
+		if len(e.conns) == 2 && s.client.Rules.MatchName(e.proc, Outbound, e.name) != e.action {
+			for _, c := range e.conns {
+				c.SetLinger(0)
+				c.Close()
+			}
+		}
+	}
+}
+
+func (s *splicer) close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.flows {
+		for _, c := range e.conns {
+			c.Close()
+		}
+	}
+	return errors.WithStack(err)
+}
+
+// nat rewrite tcp packet's address
+func nat(ip []byte, src, dst netip.AddrPort) error {
+	switch header.IPVersion(ip) {
+	case 4:
+		hdr := header.IPv4(ip)
+		hdr.SetSourceAddress(tcpip.AddrFrom4(src.Addr().As4()))
+		hdr.SetDestinationAddress(tcpip.AddrFrom4(dst.Addr().As4()))
+	case 6:
+		hdr := header.IPv6(ip)
+		hdr.SetSourceAddress(tcpip.AddrFrom16(src.Addr().As16()))
+		hdr.SetDestinationAddress(tcpip.AddrFrom16(dst.Addr().As16()))
+	}
+	tcp := header.TCP(ipPayload(ip))
+	tcp.SetSourcePort(src.Port())
+	tcp.SetDestinationPort(dst.Port())
+	return rechecksum(ip)
+}
@@ -0,0 +1,147 @@
+package fatun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type mockCapture struct {
+	Capturer
+	injected []Session
+}
+
+func (m *mockCapture) Inject(ip *packet.Packet) error {
+	s, err := FromIP(ip.Bytes())
+	if err != nil {
+		return err
+	}
+	m.injected = append(m.injected, s)
+	return nil
+}
+
+func tcpIP(t *testing.T, s Session, flags header.TCPFlags) *packet.Packet {
+	var b = make([]byte, header.IPv4MinimumSize+header.TCPMinimumSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(s.Src.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(s.Dst.Addr().As4()),
+	})
+	header.TCP(b[header.IPv4MinimumSize:]).Encode(&header.TCPFields{
+		SrcPort: s.Src.Port(), DstPort: s.Dst.Port(),
+		DataOffset: header.TCPMinimumSize, Flags: flags,
+	})
+	require.NoError(t, rechecksum(b))
+	return packet.Make(64, 0, len(b)).Append(b...)
+}
+
+func Test_Splicer_Intercept(t *testing.T) {
+	var (
+		cap = &mockCapture{}
+		c   = &Client{Logger: slog.Default(), Capturer: cap}
+	)
+	c.srvCtx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+	sp, err := newSplicer(c)
+	require.NoError(t, err)
+	defer sp.close()
+
+	var (
+		proc = Session{
+			Src:   netip.MustParseAddrPort("10.0.0.1:1234"),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.MustParseAddrPort("1.1.1.1:443"),
+		}
+		lis = Session{
+			Src:   netip.AddrPortFrom(proc.Src.Addr(), sp.port),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.MustParseAddrPort("1.1.1.1:1234"),
+		}
+	)
+
+	// not handshake packet of unknown flow
+	ok, err := sp.intercept(tcpIP(t, proc, header.TCPFlagAck), proc)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// syn be terminated by listener
+	ok, err = sp.intercept(tcpIP(t, proc, header.TCPFlagSyn), proc)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Session{Src: lis.Dst, Proto: lis.Proto, Dst: lis.Src}, cap.injected[0])
+
+	// listener reply to process
+	ok, err = sp.intercept(tcpIP(t, lis, header.TCPFlagSyn|header.TCPFlagAck), lis)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Session{Src: proc.Dst, Proto: proc.Proto, Dst: proc.Src}, cap.injected[1])
+
+	_, ok = sp.exempt(proc)
+	require.False(t, ok)
+}
+
+func Test_Splicer_Handle(t *testing.T) {
+	srv, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer srv.Close()
+	go func() {
+		conn, err := srv.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	var c = &Client{Logger: slog.Default(), sessions: newSessions()}
+	c.srvCtx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+	sp, err := newSplicer(c)
+	require.NoError(t, err)
+	defer sp.close()
+	go sp.serve()
+
+	// process connect to listener directly, as NATed by intercept, the flow
+	// registered before accepted
+	sp.mu.Lock()
+	conn, err := net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", sp.port))
+	require.NoError(t, err)
+	defer conn.Close()
+	proc := Session{
+		Src:   netip.MustParseAddrPort("127.0.0.1:1234"),
+		Proto: header.TCPProtocolNumber,
+		Dst:   netip.MustParseAddrPort(srv.Addr().String()),
+	}
+	lis := Session{
+		Src:   netip.MustParseAddrPort(conn.RemoteAddr().String()),
+		Proto: header.TCPProtocolNumber,
+		Dst:   netip.MustParseAddrPort(conn.LocalAddr().String()),
+	}
+	e := &splice{proc: proc, lis: lis}
+	sp.flows[proc], sp.peers[lis] = e, e
+	sp.mu.Unlock()
+
+	req := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	_, err = conn.Write([]byte(req))
+	require.NoError(t, err)
+	var b = make([]byte, len(req))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, req, string(b))
+
+	require.Equal(t, []SessionInfo{{Session: proc, Name: "example.com", Action: Tunnel}}, c.Sessions())
+	sp.mu.Lock()
+	require.Same(t, e, sp.exempts[e.port])
+	sp.mu.Unlock()
+}
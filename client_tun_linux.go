@@ -0,0 +1,309 @@
+//go:build linux
+// +build linux
+
+package fatun
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/lysShub/fatun/netlink"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/netkit/tun"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const DefaultTunName = "fatun"
+
+// tun capture policy routing, rules priority from tunPriority to tunPriority+2
+const (
+	tunTable    uint32 = DefaultPort
+	tunPriority uint32 = DefaultPort - 2
+)
+
+// tunFamilies address families that be routed to tun device, todo: ipv6, require
+// server's EthSender support ipv6 (neighbor, bpf and local address)
+var tunFamilies = []uint8{unix.AF_INET}
+
+// TunCapture capture outbound ip packet by tun device, all ipv4 traffic will
+// route to tun device, except the client udp connect self. ipv6 traffic not be
+// captured and go origin path, because server's sender is ipv4 only, see tunFamilies.
+// packet that can't be tunneled, e.g. icmp error, be passed by origin interface.
+//
+// notice: Direct flow's response packet be received by origin interface, that
+// require the interface's rp_filter is loose mode.
+type TunCapture struct {
+	tun      *tun.TunTap
+	ifi      *net.Interface
+	laddr    netip.AddrPort
+	overhead int
+
+	mu         sync.RWMutex // protect laddr, raw socket and routing, see Rebind
+	raw4, raw6 int          // raw socket bind to origin interface, for Pass
+
+	rules  []netlink.Rule
+	routes []netlink.Route
+
+	closeErr errorx.CloseErr
+}
+
+var _ Capturer = (*TunCapture)(nil)
+var _ Passer = (*TunCapture)(nil)
+
+// NewTunCapture create tun device and set policy routing, laddr is the client udp
+// connect local address, which will bypass the tun device.
+func NewTunCapture(name string, laddr netip.AddrPort, overhead int) (*TunCapture, error) {
+	var c = &TunCapture{laddr: laddr, overhead: overhead, raw4: -1, raw6: -1}
+	var err error
+
+	if c.tun, err = tun.Tun(name); err != nil {
+		return nil, c.close(err)
+	}
+	if c.ifi, err = net.InterfaceByName(name); err != nil {
+		return nil, c.close(errors.WithStack(err))
+	}
+
+	for _, family := range tunFamilies {
+		if err := c.capture(family); err != nil {
+			return nil, c.close(err)
+		}
+	}
+	if c.raw4 < 0 && c.raw6 < 0 {
+		return nil, c.close(errors.Errorf("not available source address"))
+	}
+	return c, nil
+}
+
+// capture route the address family's traffic to tun device
+func (c *TunCapture) capture(family uint8) (err error) {
+	var src, unspec netip.Addr
+	if family == unix.AF_INET {
+		unspec = netip.IPv4Unspecified()
+	} else {
+		unspec = netip.IPv6Unspecified()
+	}
+	if c.laddr.Addr().Is4() == (family == unix.AF_INET) {
+		src = c.laddr.Addr()
+	} else if src = preferSource(family); !src.IsValid() {
+		return nil
+	}
+
+	var raw int
+	if raw, err = rawBindTo(src); err != nil {
+		return err
+	}
+	if family == unix.AF_INET {
+		c.raw4 = raw
+	} else {
+		c.raw6 = raw
+	}
+
+	// default route via tun device, use src as prefer source address, so
+	// downlink packet can be injected without change destination address.
+	if err := c.addRoute(netlink.Route{
+		Dest:      netip.PrefixFrom(unspec, 0),
+		Src:       src,
+		Interface: uint32(c.ifi.Index),
+		Table:     tunTable,
+	}); err != nil {
+		return err
+	}
+
+	var rules []netlink.Rule
+	if src == c.laddr.Addr() {
+		// client udp connect bypass tun
+		rules = append(rules, netlink.Rule{Priority: tunPriority, Table: unix.RT_TABLE_MAIN, Proto: unix.IPPROTO_UDP, SrcPort: c.laddr.Port()})
+	}
+	rules = append(rules,
+		// keep main table non-default route, e.g. LAN
+		netlink.Rule{Priority: tunPriority + 1, Table: unix.RT_TABLE_MAIN, SuppressDefault: true},
+		netlink.Rule{Priority: tunPriority + 2, Table: tunTable},
+	)
+	for _, r := range rules {
+		r.Family = family
+		if err := c.addRule(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebind re-setup policy routing after local network changed, laddr is the
+// client udp connect new local address.
+func (c *TunCapture) Rebind(laddr netip.AddrPort) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := c.unroute()
+	c.rules, c.routes, c.raw4, c.raw6 = nil, nil, -1, -1
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	c.laddr = laddr
+	for _, family := range tunFamilies {
+		if err := c.capture(family); err != nil {
+			return err
+		}
+	}
+	if c.raw4 < 0 && c.raw6 < 0 {
+		return errors.Errorf("not available source address")
+	}
+	return nil
+}
+
+// unroute delete policy routing and raw socket
+func (c *TunCapture) unroute() (errs []error) {
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		errs = append(errs, netlink.DelRule(c.rules[i]))
+	}
+	for i := len(c.routes) - 1; i >= 0; i-- {
+		errs = append(errs, netlink.DelRoute(c.routes[i]))
+	}
+	for _, fd := range []int{c.raw4, c.raw6} {
+		if fd >= 0 {
+			errs = append(errs, errors.WithStack(unix.Close(fd)))
+		}
+	}
+	return errs
+}
+
+// preferSource get the host prefer source address to internet
+func preferSource(family uint8) netip.Addr {
+	network, addr := "udp4", "8.8.8.8:53"
+	if family == unix.AF_INET6 {
+		network, addr = "udp6", "[2001:4860:4860::8888]:53"
+	}
+	conn, err := net.Dial(network, addr) // not send packet
+	if err != nil {
+		return netip.Addr{}
+	}
+	defer conn.Close()
+	return netip.MustParseAddrPort(conn.LocalAddr().String()).Addr()
+}
+
+func (c *TunCapture) addRoute(r netlink.Route) error {
+	if err := netlink.AddRoute(r); err != nil {
+		return err
+	}
+	c.routes = append(c.routes, r)
+	return nil
+}
+
+func (c *TunCapture) addRule(r netlink.Rule) error {
+	if err := netlink.AddRule(r); err != nil {
+		return err
+	}
+	c.rules = append(c.rules, r)
+	return nil
+}
+
+func (c *TunCapture) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		c.mu.Lock()
+		errs = append(errs, c.unroute()...)
+		c.mu.Unlock()
+		if c.tun != nil {
+			errs = append(errs, c.tun.Close())
+		}
+		return errs
+	})
+}
+
+func (c *TunCapture) Name() string { return c.tun.Name() }
+
+func (c *TunCapture) Capture(ip *packet.Packet) error {
+	head, data := ip.Head(), ip.Data()
+	for {
+		n, err := c.tun.Read(context.Background(), ip.Sets(head, data).Bytes())
+		if err != nil {
+			return c.close(err)
+		}
+		ip.SetData(n)
+
+		s, err := FromIP(ip.Bytes())
+		if err != nil || s.Dst.Addr().IsMulticast() || s.Dst.Addr().IsLinkLocalUnicast() {
+			// can't be tunneled, e.g. icmp error message, send it by origin interface
+			if err := c.Pass(ip); err != nil {
+				return errorx.WrapTemp(err)
+			}
+			continue
+		} else if s.Proto == header.UDPProtocolNumber && s.Src == c.localAddr() {
+			continue // self, should not happen
+		}
+
+		if s.Proto == header.TCPProtocolNumber {
+			UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
+		}
+		return nil
+	}
+}
+
+func (c *TunCapture) localAddr() netip.AddrPort {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.laddr
+}
+
+func (c *TunCapture) Inject(ip *packet.Packet) error {
+	if s, err := FromIP(ip.Bytes()); err == nil && s.Proto == header.TCPProtocolNumber {
+		UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
+	}
+
+	_, err := c.tun.Write(context.Background(), ip.Bytes())
+	if err != nil {
+		return c.close(errors.WithStack(err))
+	}
+	return nil
+}
+
+// Pass send captured packet by origin interface
+func (c *TunCapture) Pass(ip *packet.Packet) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var err error
+	switch header.IPVersion(ip.Bytes()) {
+	case 4:
+		dst := header.IPv4(ip.Bytes()).DestinationAddress().As4()
+		err = unix.Sendto(c.raw4, ip.Bytes(), 0, &unix.SockaddrInet4{Addr: dst})
+	case 6:
+		dst := header.IPv6(ip.Bytes()).DestinationAddress().As16()
+		err = unix.Sendto(c.raw6, ip.Bytes(), 0, &unix.SockaddrInet6{Addr: dst})
+	default:
+		return errors.Errorf("invalid ip version %d", header.IPVersion(ip.Bytes()))
+	}
+	return errors.WithStack(err)
+}
+
+func (c *TunCapture) Close() error { return c.close(nil) }
+
+// rawBindTo create ip header included raw socket, that bind to the interface of addr
+func rawBindTo(addr netip.Addr) (int, error) {
+	ifi, err := ifaceByAddr(addr)
+	if err != nil {
+		return -1, err
+	}
+
+	var family = unix.AF_INET
+	if addr.Is6() {
+		family = unix.AF_INET6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_RAW)
+	if err != nil {
+		return -1, errors.WithStack(err)
+	}
+	if err := unix.BindToDevice(fd, ifi.Name); err != nil {
+		unix.Close(fd)
+		return -1, errors.WithStack(err)
+	}
+	return fd, nil
+}
@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"net/netip"
 	"sync/atomic"
+	"syscall"
 
 	"github.com/lysShub/divert-go"
 	"github.com/lysShub/netkit/errorx"
 	mapping "github.com/lysShub/netkit/mapping/process"
 	"github.com/lysShub/netkit/packet"
 	"github.com/lysShub/netkit/pcap"
+	"github.com/pkg/errors"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -39,7 +41,8 @@ func NewDefaultCapture(laddr netip.AddrPort, overhead int) (Capturer, error) {
 		}
 	}
 
-	var filter = fmt.Sprintf("outbound and !loopback and ip and ((tcp and tcp.SrcPort!=%d) or udp)", laddr.Port())
+	// ipv6 not be captured, server's sender is ipv4 only
+	var filter = fmt.Sprintf("outbound and !loopback and ip and ((tcp and tcp.SrcPort!=%d) or udp or (icmp and icmp.Type==8))", laddr.Port())
 	c.capture, err = divert.Open(filter, divert.Network, 0, 0)
 	if err != nil {
 		return nil, c.close(err)
@@ -91,7 +94,8 @@ func (c *capture) Capture(ip *packet.Packet) error {
 			return err
 		}
 		pass := s.Dst.Addr().IsMulticast()
-		if !pass {
+		if !pass && (s.Proto == header.TCPProtocolNumber || s.Proto == header.UDPProtocolNumber) {
+			// icmp echo not belong to process, always be captured
 			name, err := c.mapping.Name(s.Src, uint8(s.Proto))
 			if err != nil {
 				if errorx.Temporary(err) {
@@ -112,7 +116,7 @@ func (c *capture) Capture(ip *packet.Packet) error {
 		}
 
 		if s.Proto == header.TCPProtocolNumber {
-			UpdateTcpMssOption(header.IPv4(ip.Bytes()).Payload(), -c.overhead)
+			UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
 		}
 
 		{
@@ -127,9 +131,10 @@ func (c *capture) Capture(ip *packet.Packet) error {
 }
 
 func (c *capture) Inject(ip *packet.Packet) error {
-	if header.IPv4(ip.Bytes()).TransportProtocol() == header.TCPProtocolNumber {
-		UpdateTcpMssOption(header.IPv4(ip.Bytes()).Payload(), -c.overhead)
+	if s, err := FromIP(ip.Bytes()); err == nil && s.Proto == header.TCPProtocolNumber {
+		UpdateTcpMssOption(ipPayload(ip.Bytes()), -c.overhead)
 	}
+	c.inbound.SetIPv6(header.IPVersion(ip.Bytes()) == 6)
 
 	{
 		err := c.pcap.WriteIP(ip.Bytes())
@@ -142,3 +147,18 @@ func (c *capture) Inject(ip *packet.Packet) error {
 	return err
 }
 func (c *capture) Close() error { return c.close(nil) }
+
+func newProcessMapping() (ProcessMapping, error) {
+	return mapping.New()
+}
+
+// roamService todo: watch network change by NotifyIpInterfaceChange
+func (c *Client) roamService() {}
+
+// dnsSnoopService todo: snoop direct dns response by divert sniff mode
+func (c *Client) dnsSnoopService() {}
+
+// bindAny todo: not splice tcp flow on windows, capturer not capture client self
+func bindAny(c syscall.RawConn, ipv6 bool) (port uint16, err error) {
+	return 0, errors.New("not support")
+}
@@ -0,0 +1,154 @@
+package conn
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Credential client credential, authenticated after tls/noise handshake,
+// Token be used if it's not empty, otherwise Username and Password.
+type Credential struct {
+	Token string
+
+	Username string
+	Password string
+}
+
+type Authenticator interface {
+	// Authenticate return authenticated user name and the credential's version,
+	// the error message will be sent to client as reject reason.
+	Authenticate(cred Credential) (user, version string, err error)
+
+	// Check the authenticated user still be authorized, e.g. not revoked and
+	// credential not be changed (version mismatch), used by periodic recheck
+	// and session resumption, without credential.
+	Check(user, version string) error
+}
+
+type credKind uint8
+
+const (
+	credNone credKind = iota
+	credToken
+	credPassword
+)
+
+const authRecheck = time.Second * 10
+
+// authenticate client credential by builtin secure conn:
+//
+//	client -> server: {kind} {len 2B} {username} {len 2B} {secret}
+//	server -> client: {ok} {len 2B} {user or reject reason}
+func (c *conn) authenticate(tconn net.Conn) error {
+	if c.role.Client() {
+		var kind, user, secret = credNone, "", ""
+		if cred := c.config.Credential; cred != nil {
+			if cred.Token != "" {
+				kind, secret = credToken, cred.Token
+			} else {
+				kind, user, secret = credPassword, cred.Username, cred.Password
+			}
+		}
+		b := []byte{byte(kind)}
+		b = appendString(b, user)
+		b = appendString(b, secret)
+		if _, err := tconn.Write(b); err != nil {
+			return errors.WithStack(err)
+		}
+
+		var ok = make([]byte, 1)
+		if _, err := io.ReadFull(tconn, ok); err != nil {
+			return errors.WithStack(err)
+		}
+		msg, err := readString(tconn)
+		if err != nil {
+			return err
+		} else if ok[0] == 0 {
+			return errors.Errorf("authenticate rejected: %s", msg)
+		}
+		c.user = msg
+		return nil
+	} else {
+		var kind = make([]byte, 1)
+		if _, err := io.ReadFull(tconn, kind); err != nil {
+			return errors.WithStack(err)
+		}
+		user, err := readString(tconn)
+		if err != nil {
+			return err
+		}
+		secret, err := readString(tconn)
+		if err != nil {
+			return err
+		}
+
+		var cred Credential
+		switch credKind(kind[0]) {
+		case credNone:
+		case credToken:
+			cred.Token = secret
+		case credPassword:
+			cred.Username, cred.Password = user, secret
+		default:
+			return errors.Errorf("invalid credential kind %d", kind[0])
+		}
+
+		if c.config.Users != nil {
+			var version string
+			if credKind(kind[0]) == credNone {
+				err = errors.New("require credential")
+			} else {
+				user, version, err = c.config.Users.Authenticate(cred)
+			}
+			if err != nil {
+				tconn.Write(appendString([]byte{0}, err.Error()))
+				return errors.WithMessage(err, "authenticate rejected")
+			}
+			c.identity.User, c.credVersion = user, version
+		}
+		_, err = tconn.Write(appendString([]byte{1}, c.identity.User))
+		return errors.WithStack(err)
+	}
+}
+
+// authService server recheck authenticated user periodically, close conn with
+// reason if the user be revoked or it's credential be changed
+func (c *conn) authService() {
+	var ticker = time.NewTicker(authRecheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.config.Users.Check(c.identity.User, c.credVersion); err != nil {
+			c.builtin.writeFrame(frameClose, []byte(err.Error()))
+			c.close(errors.WithMessagef(err, "user %s", c.identity.User))
+			return
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(r io.Reader) (string, error) {
+	var n = make([]byte, 2)
+	if _, err := io.ReadFull(r, n); err != nil {
+		return "", errors.WithStack(err)
+	}
+	var b = make([]byte, binary.BigEndian.Uint16(n))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(b), nil
+}
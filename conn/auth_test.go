@@ -0,0 +1,102 @@
+package conn
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func userFile(t *testing.T) string {
+	hash, err := HashPassword("123456")
+	require.NoError(t, err)
+	var b = "# name kind hash\n" +
+		"alice password " + hash + "\n" +
+		"bob   token    " + HashToken("bob-token") + "\n" +
+		"carol token    " + HashToken("carol-token") + " revoked\n"
+
+	path := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(path, []byte(b), 0600))
+	return path
+}
+
+func Test_UserFile(t *testing.T) {
+	path := userFile(t)
+	f, err := NewUserFile(path)
+	require.NoError(t, err)
+
+	name, _, err := f.Authenticate(Credential{Username: "alice", Password: "123456"})
+	require.NoError(t, err)
+	require.Equal(t, "alice", name)
+	name, version, err := f.Authenticate(Credential{Token: "bob-token"})
+	require.NoError(t, err)
+	require.Equal(t, "bob", name)
+	require.NoError(t, f.Check("bob", version))
+
+	_, _, err = f.Authenticate(Credential{Username: "alice", Password: "654321"})
+	require.Error(t, err)
+	_, _, err = f.Authenticate(Credential{Username: "bob", Password: "bob-token"})
+	require.Error(t, err)
+	_, _, err = f.Authenticate(Credential{Token: "carol-token"})
+	require.Error(t, err)
+
+	// rotate bob's token
+	require.NoError(t, os.WriteFile(path, []byte("bob token "+HashToken("bob-token2")+"\n"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	require.EqualError(t, f.Check("bob", version), "credential changed")
+
+	// revoke bob
+	require.NoError(t, os.WriteFile(path, []byte("bob token "+HashToken("bob-token")+" revoked\n"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second*2)))
+	_, _, err = f.Authenticate(Credential{Token: "bob-token"})
+	require.EqualError(t, err, "credential revoked")
+	require.EqualError(t, f.Check("bob", version), "credential revoked")
+
+	require.NoError(t, os.WriteFile(path, []byte("alice password invalid\n"), 0600))
+	_, err = NewUserFile(path)
+	require.Error(t, err)
+}
+
+func Test_Authenticate(t *testing.T) {
+	users, err := NewUserFile(userFile(t))
+	require.NoError(t, err)
+
+	auth := func(cred *Credential) (*conn, *conn, error, error) {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		c := &conn{role: client, config: &Config{Credential: cred}}
+		s := &conn{role: server, config: &Config{Users: users}}
+
+		var rets = make(chan error, 1)
+		go func() {
+			rets <- s.authenticate(b)
+		}()
+		err := c.authenticate(a)
+		return c, s, err, <-rets
+	}
+
+	c, s, cerr, serr := auth(&Credential{Token: "bob-token"})
+	require.NoError(t, cerr)
+	require.NoError(t, serr)
+	require.Equal(t, "bob", c.User())
+	require.Equal(t, "bob", s.identity.User)
+	require.Empty(t, c.identity.User, "server not authenticated by credential")
+
+	c, s, cerr, serr = auth(&Credential{Username: "alice", Password: "123456"})
+	require.NoError(t, cerr)
+	require.NoError(t, serr)
+	require.Equal(t, "alice", c.User())
+	require.Equal(t, "alice", s.identity.User)
+
+	_, _, cerr, serr = auth(&Credential{Token: "carol-token"})
+	require.ErrorContains(t, cerr, "credential revoked")
+	require.Error(t, serr)
+
+	_, _, cerr, serr = auth(nil)
+	require.ErrorContains(t, cerr, "require credential")
+	require.Error(t, serr)
+}
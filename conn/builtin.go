@@ -0,0 +1,126 @@
+package conn
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+)
+
+// builtin stream carry user data and control messages, framed as:
+//
+//	{type 1B} {length 2B} {payload}
+type frameType uint8
+
+const (
+	_ frameType = iota
+	frameData
+	frameRekey    // server -> client: {epoch} {key}
+	frameRekeyAck // client -> server: {epoch}
+	frameRekeyReq // client -> server: request rekey
+	frameClose    // server -> client: {reason}
+	frameTicket   // server -> client: resumption ticket
+)
+
+const maxFramePayload = 0xffff
+
+type builtin struct {
+	conn   net.Conn // builtin tcp/tls conn
+	handle func(typ frameType, payload []byte) error
+
+	wmu  sync.Mutex
+	user net.Conn // user side, return by BuiltinConn
+	pipe net.Conn
+
+	closeErr errorx.CloseErr
+}
+
+func newBuiltin(conn net.Conn, handle func(typ frameType, payload []byte) error) *builtin {
+	var b = &builtin{conn: conn, handle: handle}
+	user, pipe := net.Pipe()
+	b.user = &builtinConn{Conn: user, conn: conn}
+	b.pipe = pipe
+
+	go b.readService()
+	go b.writeService()
+	return b
+}
+
+func (b *builtin) close(cause error) error {
+	return b.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		errs = append(errs, b.user.Close())
+		errs = append(errs, b.pipe.Close())
+		errs = append(errs, b.conn.Close())
+		return errs
+	})
+}
+
+func (b *builtin) writeFrame(typ frameType, payload []byte) error {
+	if len(payload) > maxFramePayload {
+		return errors.Errorf("builtin frame payload too large %d", len(payload))
+	}
+	var frame = make([]byte, 3, 3+len(payload))
+	frame[0] = byte(typ)
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(payload)))
+	frame = append(frame, payload...)
+
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	_, err := b.conn.Write(frame)
+	return errors.WithStack(err)
+}
+
+func (b *builtin) readService() (_ error) {
+	var (
+		hdr     = make([]byte, 3)
+		payload = make([]byte, maxFramePayload)
+	)
+	for {
+		if _, err := io.ReadFull(b.conn, hdr); err != nil {
+			return b.close(errors.WithStack(err))
+		}
+		n := int(binary.BigEndian.Uint16(hdr[1:]))
+		if _, err := io.ReadFull(b.conn, payload[:n]); err != nil {
+			return b.close(errors.WithStack(err))
+		}
+
+		switch typ := frameType(hdr[0]); typ {
+		case frameData:
+			if _, err := b.pipe.Write(payload[:n]); err != nil {
+				return b.close(errors.WithStack(err))
+			}
+		default:
+			if err := b.handle(typ, payload[:n]); err != nil {
+				return b.close(err)
+			}
+		}
+	}
+}
+
+func (b *builtin) writeService() (_ error) {
+	var buff = make([]byte, maxFramePayload)
+	for {
+		n, err := b.pipe.Read(buff)
+		if err != nil {
+			return b.close(errors.WithStack(err))
+		}
+		if err = b.writeFrame(frameData, buff[:n]); err != nil {
+			return b.close(err)
+		}
+	}
+}
+
+func (b *builtin) Close() error { return b.close(nil) }
+
+// builtinConn user side of builtin stream, address is the builtin conn's
+type builtinConn struct {
+	net.Conn
+	conn net.Conn
+}
+
+func (c *builtinConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *builtinConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
@@ -0,0 +1,134 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+)
+
+const (
+	certCheckInterval = time.Second * 10
+	certExpireWarn    = time.Hour * 24 * 30
+)
+
+// CertFile hot-reloadable server certificate, watch the cert and key files,
+// swap the certificate atomically if they modified, only affect new handshake,
+// established conn keep working. warn if certificate will expire in 30 days.
+//
+//	cfg := &conn.Config{TLS: &tls.Config{GetCertificate: certFile.GetCertificate}}
+type CertFile struct {
+	certPath, keyPath string
+	logger            *slog.Logger
+
+	cert     atomic.Pointer[tls.Certificate]
+	mod      [2]time.Time // cert, key
+	lastWarn time.Time
+
+	closed   chan struct{}
+	closeErr errorx.CloseErr
+}
+
+// NewCertFile logger report reload failure and expiry warning, default slog.Default()
+func NewCertFile(certPath, keyPath string, logger *slog.Logger) (*CertFile, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	var f = &CertFile{
+		certPath: certPath, keyPath: keyPath,
+		logger: logger,
+		closed: make(chan struct{}),
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	f.checkExpire()
+
+	go f.watchService()
+	return f, nil
+}
+
+// reload return true if certificate be swapped
+func (f *CertFile) reload() (bool, error) {
+	var mod [2]time.Time
+	for i, e := range []string{f.certPath, f.keyPath} {
+		info, err := os.Stat(e)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		mod[i] = info.ModTime()
+	}
+	if mod == f.mod {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+
+	f.cert.Store(&cert)
+	f.mod = mod
+	return true, nil
+}
+
+func (f *CertFile) checkExpire() {
+	leaf := f.cert.Load().Leaf
+	remain := time.Until(leaf.NotAfter)
+	if remain > certExpireWarn || time.Since(f.lastWarn) < time.Hour {
+		return
+	}
+	f.lastWarn = time.Now()
+	if remain <= 0 {
+		f.logger.Error("tls certificate expired",
+			slog.String("cert", f.certPath), slog.Time("not-after", leaf.NotAfter))
+	} else {
+		f.logger.Warn("tls certificate will expire",
+			slog.String("cert", f.certPath), slog.Time("not-after", leaf.NotAfter), slog.Duration("remain", remain))
+	}
+}
+
+func (f *CertFile) watchService() {
+	var ticker = time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if ok, err := f.reload(); err != nil {
+			// maybe be writing, keep previous certificate
+			f.logger.Warn(err.Error(), errorx.Trace(err))
+		} else if ok {
+			f.logger.Info("tls certificate reloaded",
+				slog.String("cert", f.certPath), slog.Time("not-after", f.cert.Load().Leaf.NotAfter))
+			f.lastWarn = time.Time{}
+		}
+		f.checkExpire()
+	}
+}
+
+// GetCertificate set as tls.Config.GetCertificate
+func (f *CertFile) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f.cert.Load(), nil
+}
+
+func (f *CertFile) Close() error {
+	return f.closeErr.Close(func() (errs []error) {
+		close(f.closed)
+		return nil
+	})
+}
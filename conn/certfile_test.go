@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, cert tls.Certificate, certPath, keyPath string, mod time.Time) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	require.NoError(t, os.Chtimes(certPath, mod, mod))
+	require.NoError(t, os.Chtimes(keyPath, mod, mod))
+}
+
+func Test_CertFile(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certPath = filepath.Join(dir, "cert.pem")
+		keyPath  = filepath.Join(dir, "key.pem")
+		log      = &strings.Builder{}
+	)
+	cert1, err := SelfSigned("example.com")
+	require.NoError(t, err)
+	writeCert(t, cert1, certPath, keyPath, time.Now())
+
+	f, err := NewCertFile(certPath, keyPath, slog.New(slog.NewTextHandler(log, nil)))
+	require.NoError(t, err)
+	defer f.Close()
+	cert, err := f.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert1.Certificate, cert.Certificate)
+	require.Zero(t, log.Len())
+
+	// not reload if not modified
+	ok, err := f.reload()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// swap to certificate near expiry
+	cert2, _ := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "example.com"}}, nil, nil)
+	writeCert(t, cert2, certPath, keyPath, time.Now().Add(time.Second))
+	ok, err = f.reload()
+	require.NoError(t, err)
+	require.True(t, ok)
+	cert, err = f.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert2.Certificate, cert.Certificate)
+
+	f.checkExpire()
+	require.Contains(t, log.String(), "tls certificate will expire")
+
+	// keep previous certificate if reload failed
+	require.NoError(t, os.WriteFile(certPath, []byte("invalid"), 0600))
+	require.NoError(t, os.Chtimes(certPath, time.Now().Add(time.Second*2), time.Now().Add(time.Second*2)))
+	_, err = f.reload()
+	require.Error(t, err)
+	cert, err = f.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert2.Certificate, cert.Certificate)
+}
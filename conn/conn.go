@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"net/netip"
+	"slices"
 	"sync/atomic"
 	"time"
 
@@ -26,11 +27,74 @@ import (
 type Config struct {
 	MaxRecvBuff int
 
+	// TLS builtin tcp tls config, server require and verify client certificate
+	// for mutual tls by set ClientAuth as tls.RequireAndVerifyClientCert, then
+	// the client identity can be got by Conn.Identity.
 	TLS *tls.Config
 
+	// Noise use Noise IK handshake instead of tls, authenticate by static
+	// Curve25519 keys, ignored if TLS is set.
+	Noise *NoiseConfig
+
+	// Credential client credential, authenticated by server after tls/noise
+	// handshake
+	Credential *Credential
+	// Users server authenticate client's Credential, see UserFile. client be
+	// rejected if not provide credential, require TLS or Noise.
+	Users Authenticator
+
+	// Resumption session resumption, reconnecting client restore session by
+	// ticket in one round trip, require TLS or Noise. tls.Config.ClientSessionCache
+	// also can be set for tls session resumption.
+	Resumption *Resumption
+
+	// CipherSuites data-plane cipher suites in preference order, negotiated
+	// after tls/noise handshake, server's preference be used. default is
+	// AES-GCM and ChaCha20-Poly1305, ChaCha20-Poly1305 first if not AES
+	// hardware support.
+	CipherSuites []CipherSuite
+
+	// Policy per-flow data-plane protection mode, default Encrypt all flows. the
+	// packet protected weaker than local policy will be rejected by Recv, so the
+	// policy of client and server should be consistent. Plain allows off-path
+	// injection, anyone know the tunnel address can forge the flow's packets.
+	Policy Policy
+
+	// RekeyInterval data-plane key rekey interval, default 1 hour
+	RekeyInterval time.Duration
+	// RekeyBytes data-plane key rekey after sent bytes, default 64GB
+	RekeyBytes uint64
+	// RekeyGrace old key still be accepted duration after rekey, default 5s
+	RekeyGrace time.Duration
+
 	PcapBuiltinPath string
 }
 
+func (c *Config) cipherSuites() []CipherSuite {
+	if len(c.CipherSuites) == 0 {
+		return defaultSuites()
+	}
+	return c.CipherSuites
+}
+func (c *Config) rekeyInterval() time.Duration {
+	if c.RekeyInterval <= 0 {
+		return time.Hour
+	}
+	return c.RekeyInterval
+}
+func (c *Config) rekeyBytes() uint64 {
+	if c.RekeyBytes == 0 {
+		return 64 << 30
+	}
+	return c.RekeyBytes
+}
+func (c *Config) rekeyGrace() time.Duration {
+	if c.RekeyGrace <= 0 {
+		return time.Second * 5
+	}
+	return c.RekeyGrace
+}
+
 type Conn interface {
 
 	// BuiltinConn get builtin stream connect, require Recv be called async.
@@ -41,6 +105,16 @@ type Conn interface {
 
 	LocalAddr() netip.AddrPort
 	RemoteAddr() netip.AddrPort
+
+	// Identity peer identity verified by handshake, valid after handshaked,
+	// e.g. BuiltinConn or first Recv returned
+	Identity() Identity
+	// User client self user name authenticated by server by Credential, valid
+	// after handshaked, empty for server
+	User() string
+	// Session server session id, kept by resumed conn, zero if not enable
+	// Resumption
+	Session() SessionID
 	Close() error
 }
 
@@ -58,10 +132,19 @@ type conn struct {
 
 	handshakedNotify       chan struct{}
 	handshaked             atomic.Bool // start or final handshake
-	builtin                net.Conn    // builtin tcp conn
+	builtin                *builtin    // builtin tcp conn
 	handshakeRecvedPackets chan *packet.Packet
 
-	crypto *crypto
+	crypto      *keychain
+	identity    Identity
+	user        string    // client: self user authenticated by server
+	credVersion string    // server: authenticated credential version, see Authenticator
+	noiseKey    []byte    // server: noise client static key
+	certs       [][]byte  // server: verified tls client certificate chain
+	authTime    time.Time // server: full handshake time
+	session     SessionID // server: see Resumption
+	rekeyReq    chan struct{}
+	closed      chan struct{}
 
 	closeErr errorx.CloseErr
 }
@@ -97,6 +180,9 @@ func newConn(dgramConn net.Conn, peer Peer, role role, ep *ustack.LinkEndpoint,
 
 		handshakedNotify:       make(chan struct{}),
 		handshakeRecvedPackets: make(chan *packet.Packet, 8),
+
+		rekeyReq: make(chan struct{}, 1),
+		closed:   make(chan struct{}),
 	}
 	if fact == nil {
 		c.tcpFactory = c.clientFactory
@@ -116,6 +202,7 @@ func newConn(dgramConn net.Conn, peer Peer, role role, ep *ustack.LinkEndpoint,
 func (c *conn) close(cause error) error {
 	return c.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
+		close(c.closed)
 		if c.builtin != nil {
 			errs = append(errs, c.builtin.Close())
 		}
@@ -134,7 +221,7 @@ func (c *conn) BuiltinConn(ctx context.Context) (conn net.Conn, err error) {
 	if err := c.handshake(ctx); err != nil {
 		return nil, c.close(err)
 	}
-	return c.builtin, nil
+	return c.builtin.user, nil
 }
 
 func (c *conn) recv(pkt *packet.Packet) (err error) {
@@ -175,11 +262,22 @@ func (c *conn) Recv(peer Peer, pkt *packet.Packet) (err error) {
 			c.inboundBuitinPacket(pkt)
 		} else {
 			if c.crypto != nil {
-				err = c.crypto.decrypt(pkt.AttachN(c.peer.Overhead()))
+				mode, err := c.crypto.decrypt(pkt.AttachN(peer.Overhead()), peer.Overhead())
 				if err != nil {
 					return err
 				}
-				pkt.DetachN(c.peer.Overhead())
+				pkt.DetachN(peer.Overhead())
+
+				if want := c.mode(peer, pkt.Bytes(), false); mode.Weaker(want) {
+					return errorx.WrapTemp(errors.Errorf("%s packet %s, require %s", mode, peer, want))
+				}
+
+				// authenticated packet, follow client address changed. Plain packet
+				// not be authenticated and replay checked, can't prove source. the
+				// migrator resist hijack by race, see udp.acceptConn.Migrate
+				if m, ok := c.conn.(migrator); ok && c.role.Server() && mode != Plain {
+					m.Migrate()
+				}
 			}
 			return nil
 		}
@@ -190,12 +288,25 @@ func (c *conn) Send(peer Peer, pkt *packet.Packet) (err error) {
 		return c.close(err)
 	}
 
+	var mode = Encrypt
+	if !peer.IsBuiltin() && c.crypto != nil {
+		mode = c.mode(peer, pkt.Bytes(), true)
+	}
 	if err = peer.Encode(pkt); err != nil {
 		return c.close(err)
 	}
 
 	if !peer.IsBuiltin() && c.crypto != nil {
-		c.crypto.encrypt(pkt)
+		if err = c.crypto.encrypt(pkt, peer.Overhead(), mode); err != nil {
+			return c.close(err)
+		}
+		if c.role.Client() && c.crypto.sender().sent.Load() >= c.config.rekeyBytes() && c.crypto.request() {
+			go func() {
+				if err := c.builtin.writeFrame(frameRekeyReq, nil); err != nil {
+					c.close(err)
+				}
+			}()
+		}
 	}
 
 	_, err = c.conn.Write(pkt.Bytes())
@@ -211,7 +322,25 @@ func (c *conn) LocalAddr() netip.AddrPort {
 func (c *conn) RemoteAddr() netip.AddrPort {
 	return netip.MustParseAddrPort(c.conn.RemoteAddr().String())
 }
-func (c *conn) Close() error { return c.close(nil) }
+func (c *conn) Identity() Identity { return c.identity }
+func (c *conn) User() string       { return c.user }
+func (c *conn) Session() SessionID { return c.session }
+func (c *conn) Close() error       { return c.close(nil) }
+
+// migrator datagram conn support client address migration, see udp.Listener
+type migrator interface {
+	Migrate() (old netip.AddrPort, migrated bool)
+}
+
+// Rebind client rebind datagram socket after local network changed, require
+// datagram conn support, see udp.Conn
+func (c *conn) Rebind() error {
+	r, ok := c.conn.(interface{ Rebind() error })
+	if !ok || c.role.Server() {
+		return errors.Errorf("%T not support rebind", c.conn)
+	}
+	return r.Rebind()
+}
 
 func (c *conn) outboundService() error {
 	var (
@@ -282,7 +411,22 @@ func (c *conn) handshake(ctx context.Context) (err error) {
 	stop := context.AfterFunc(ctx, func() { tcp.SetDeadline(time.Now()) })
 	defer stop()
 
-	if c.config.TLS != nil {
+	var (
+		secure  net.Conn
+		suite   CipherSuite
+		dkey    key
+		resumed bool
+	)
+	if c.config.TLS != nil || c.config.Noise != nil {
+		if secure, suite, dkey, err = c.resume(tcp); err != nil {
+			return err
+		}
+		resumed = secure != nil
+	}
+
+	switch {
+	case resumed:
+	case c.config.TLS != nil:
 		var tconn *tls.Conn
 		if c.role.Client() {
 			tconn = tls.Client(tcp, c.config.TLS)
@@ -292,35 +436,143 @@ func (c *conn) handshake(ctx context.Context) (err error) {
 		if err := tconn.HandshakeContext(ctx); err != nil {
 			return errors.WithStack(err)
 		}
-
-		var key key
-		if c.role.Server() {
-			if n, err := rand.Read(key[:]); err != nil {
-				return errors.WithStack(err)
-			} else if n != len(key) {
-				return errors.Errorf("crypto rand too small %d", n)
-			}
-			if _, err = tconn.Write(key[:]); err != nil {
-				return errors.WithStack(err)
+		secure, c.identity = tconn, tlsIdentity(tconn.ConnectionState())
+		if c.role.Server() && c.identity.Valid() {
+			for _, e := range tconn.ConnectionState().PeerCertificates {
+				c.certs = append(c.certs, e.Raw)
 			}
+		}
+	case c.config.Noise != nil:
+		if c.role.Client() {
+			secure, err = noiseClient(tcp, c.config.Noise)
 		} else {
-			if _, err := io.ReadFull(tconn, key[:]); err != nil {
-				return errors.WithStack(err)
+			secure, err = noiseServer(tcp, c.config.Noise)
+		}
+		if err != nil {
+			return err
+		}
+		c.identity = secure.(*noiseConn).Identity()
+		if c.role.Server() {
+			c.noiseKey = secure.(*noiseConn).RemoteKey().Bytes()
+		}
+	}
+
+	if secure != nil {
+		if !resumed {
+			c.authTime = time.Now()
+			if err := c.authenticate(secure); err != nil {
+				return err
+			}
+			if suite, dkey, err = c.negotiate(secure); err != nil {
+				return err
 			}
 		}
-		c.crypto, err = newCrypto(key, c.peer.Overhead())
+		c.crypto, err = newKeychain(suite, dkey, c.role, c.config.rekeyGrace())
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		c.builtin = tconn
+		c.builtin = newBuiltin(secure, c.handleFrame)
+		if c.role.Server() {
+			go c.rekeyService()
+			if c.config.Users != nil {
+				go c.authService()
+			}
+			if c.config.Resumption != nil {
+				if !resumed {
+					if _, err := rand.Read(c.session[:]); err != nil {
+						return errors.WithStack(err)
+					}
+				}
+				if err := c.issueTicket(); err != nil {
+					return err
+				}
+			}
+		}
+	} else if c.config.Users != nil || c.config.Credential != nil {
+		return errors.New("authenticate require TLS or Noise")
 	} else {
-		c.builtin = tcp
+		c.builtin = newBuiltin(tcp, c.handleFrame)
 	}
 
 	close(c.handshakedNotify)
 	return nil
 }
+
+// negotiate data-plane cipher suite and key by builtin secure conn, client send
+// supported suites, server select suite by it's preference and generate key:
+//
+//	client -> server: {count} {suites...}
+//	server -> client: {suite} {key}
+func (c *conn) negotiate(tconn net.Conn) (suite CipherSuite, key key, err error) {
+	if c.role.Client() {
+		if _, err = tconn.Write(appendSuites(nil, c.config.cipherSuites())); err != nil {
+			return 0, key, errors.WithStack(err)
+		}
+		return c.selected(tconn)
+	} else {
+		suites, err := readSuites(tconn)
+		if err != nil {
+			return 0, key, err
+		}
+		return c.selectKey(tconn, suites)
+	}
+}
+
+func appendSuites(b []byte, suites []CipherSuite) []byte {
+	b = append(b, byte(len(suites)))
+	for _, e := range suites {
+		b = append(b, byte(e))
+	}
+	return b
+}
+
+func readSuites(r io.Reader) ([]CipherSuite, error) {
+	var n = make([]byte, 1)
+	if _, err := io.ReadFull(r, n); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b := make([]byte, n[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var suites []CipherSuite
+	for _, e := range b {
+		suites = append(suites, CipherSuite(e))
+	}
+	return suites, nil
+}
+
+// selected client read server selected suite and key
+func (c *conn) selected(r io.Reader) (suite CipherSuite, key key, err error) {
+	b := make([]byte, 1+len(key))
+	if _, err = io.ReadFull(r, b); err != nil {
+		return 0, key, errors.WithStack(err)
+	}
+	suite = CipherSuite(b[0])
+	if !slices.Contains(c.config.cipherSuites(), suite) {
+		return 0, key, errors.Errorf("server selected not supported cipher suite %s", suite)
+	}
+	return suite, [len(key)]byte(b[1:]), nil
+}
+
+// selectKey server select suite of client supported and generate key
+func (c *conn) selectKey(w io.Writer, suites []CipherSuite) (suite CipherSuite, key key, err error) {
+	if suite, err = selectSuite(c.config.cipherSuites(), suites); err != nil {
+		return 0, key, err
+	}
+
+	if n, err := rand.Read(key[:]); err != nil {
+		return 0, key, errors.WithStack(err)
+	} else if n != len(key) {
+		return 0, key, errors.Errorf("crypto rand too small %d", n)
+	}
+	if _, err = w.Write(append([]byte{byte(suite)}, key[:]...)); err != nil {
+		return 0, key, errors.WithStack(err)
+	}
+	return suite, key, nil
+}
+
 func (c *conn) handshakeInboundService(retch chan struct{}) (_ error) {
 	var (
 		tcp  = packet.Make(c.config.MaxRecvBuff)
@@ -347,7 +599,7 @@ func (c *conn) handshakeInboundService(retch chan struct{}) (_ error) {
 				c.inboundBuitinPacket(tcp)
 			} else {
 				select {
-				case c.handshakeRecvedPackets <- tcp.AttachN(c.peer.Overhead()).Clone():
+				case c.handshakeRecvedPackets <- tcp.AttachN(peer.Overhead()).Clone():
 				default:
 					// todo: log
 				}
@@ -369,7 +621,7 @@ func (c *conn) inboundBuitinPacket(tcp *packet.Packet) {
 	if debug.Debug() {
 		hdr := header.TCP(tcp.Bytes())
 		require.Equal(test.T(), c.LocalAddr().Port(), hdr.DestinationPort())
-		require.Equal(test.T(), c.RemoteAddr().Port(), hdr.SourcePort())
+		require.Equal(test.T(), c.ep.RemoteAddr().Port(), hdr.SourcePort())
 	}
 	c.ep.Inbound(tcp)
 }
@@ -0,0 +1,114 @@
+package conn
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type migrateConn struct {
+	net.Conn
+	pkts     [][]byte
+	migrated int
+}
+
+func (m *migrateConn) Read(b []byte) (int, error) {
+	n := copy(b, m.pkts[0])
+	m.pkts = m.pkts[1:]
+	return n, nil
+}
+func (m *migrateConn) Migrate() (netip.AddrPort, bool) {
+	m.migrated++
+	return netip.AddrPort{}, true
+}
+
+func Test_Conn_Migrate(t *testing.T) {
+	cli, err := newKeychain(AES128GCM, key{1, 2, 3}, client, time.Second)
+	require.NoError(t, err)
+	srv, err := newKeychain(AES128GCM, key{1, 2, 3}, server, time.Second)
+	require.NoError(t, err)
+
+	var (
+		raw = &migrateConn{}
+		c   = &conn{role: server, peer: NewFamilyPeer(), conn: raw, crypto: srv,
+			handshakedNotify: make(chan struct{}),
+			config: &Config{
+				// dns flow allow plain
+				Policy: func(proto tcpip.TransportProtocolNumber, remote netip.AddrPort) Mode {
+					if remote.Port() == 53 {
+						return Plain
+					}
+					return Encrypt
+				},
+			},
+		}
+		p = NewFamilyPeer().Reset(header.UDPProtocolNumber, netip.MustParseAddr("8.8.8.8"))
+	)
+	c.handshaked.Store(true)
+	close(c.handshakedNotify)
+
+	for _, mode := range []Mode{Plain, Encrypt} {
+		udp := header.UDP(make([]byte, header.UDPMinimumSize))
+		udp.Encode(&header.UDPFields{SrcPort: 19986, DstPort: 53})
+		pkt := packet.Make(64, 0, 64).Append(udp...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, cli.encrypt(pkt, p.Overhead(), mode))
+		raw.pkts = append(raw.pkts, pkt.Bytes())
+	}
+
+	// plain packet can be spoofed by off-path attacker, not migrate
+	require.NoError(t, c.Recv(NewFamilyPeer(), packet.Make(64, 1500)))
+	require.Zero(t, raw.migrated)
+
+	require.NoError(t, c.Recv(NewFamilyPeer(), packet.Make(64, 1500)))
+	require.Equal(t, 1, raw.migrated)
+}
+
+func Test_Conn_Policy(t *testing.T) {
+	cli, err := newKeychain(AES128GCM, key{1, 2, 3}, client, time.Second)
+	require.NoError(t, err)
+	srv, err := newKeychain(AES128GCM, key{1, 2, 3}, server, time.Second)
+	require.NoError(t, err)
+
+	var (
+		raw = &migrateConn{}
+		c   = &conn{role: server, peer: NewFamilyPeer(), conn: raw, crypto: srv,
+			handshakedNotify: make(chan struct{}),
+			config: &Config{
+				Policy: func(proto tcpip.TransportProtocolNumber, remote netip.AddrPort) Mode {
+					if remote.Port() == 443 {
+						return Authenticate
+					}
+					return Encrypt
+				},
+			},
+		}
+		p = NewFamilyPeer().Reset(header.TCPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+	)
+	c.handshaked.Store(true)
+	close(c.handshakedNotify)
+
+	for _, mode := range []Mode{Plain, Authenticate} {
+		tcp := header.TCP(make([]byte, header.TCPMinimumSize))
+		tcp.Encode(&header.TCPFields{SrcPort: 19986, DstPort: 443, DataOffset: header.TCPMinimumSize})
+		pkt := packet.Make(64, 0, 64).Append(tcp...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, cli.encrypt(pkt, p.Overhead(), mode))
+		raw.pkts = append(raw.pkts, pkt.Bytes())
+	}
+
+	// plain packet of the flow require authenticate, e.g. injected by off-path attacker
+	err = c.Recv(NewFamilyPeer(), packet.Make(64, 1500))
+	require.True(t, errorx.Temporary(err), err)
+	require.Zero(t, raw.migrated)
+
+	require.NoError(t, c.Recv(NewFamilyPeer(), packet.Make(64, 1500)))
+	require.Equal(t, 1, raw.migrated)
+}
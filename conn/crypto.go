@@ -1,56 +1,182 @@
 package conn
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
 
+	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
 	"github.com/pkg/errors"
 )
 
-type key = [16]byte
+// key max key size of cipher suites, the suite use key prefix
+type key = [32]byte
 
-const bytes = 16
+const (
+	bytes        = 16 // aead tag size
+	trailerBytes = 8  // {mode 2b, epoch 6b} {counter 7B}
 
-// 加密不包括peer头
+	maxCounter = 1<<56 - 1
+	epochMask  = 0x3f
+)
+
+// 加密不包括peer头, peer头作为附加数据被认证
 // 加密不包括builtin包（其实可以包括）
+// 数据包格式: {peer} {密文} {tag} {mode|epoch} {counter}
+// mode 为包的保护方式, 见 Mode; epoch 为密钥的版本, 见 keychain;
+// nonce 由发送方角色和trailer组成, counter 每个方向单调递增, 保证同一密钥下nonce不重复;
+// 接收方用滑动窗口过滤重放的counter
+// Authenticate 或 AuthOnly 时不加密, 整个包作为附加数据认证; Plain 时没有tag, 不做任何处理
 type crypto struct {
-	c          cipher.AEAD
-	headerSize int
+	aead    cipher.AEAD
+	auth    bool // authenticate only
+	role    role // local role, as send nonce prefix
+	epoch   uint8
+	counter atomic.Uint64
+	sent    atomic.Uint64 // sent bytes
+	replay  replay
 }
 
-func newCrypto(key key, headerSize int) (*crypto, error) {
-	var c = &crypto{headerSize: headerSize}
-	if block, err := aes.NewCipher(key[:]); err != nil {
+func newCrypto(suite CipherSuite, key key, role role, epoch uint8) (*crypto, error) {
+	aead, err := suite.aead(key)
+	if err != nil {
 		return nil, err
-	} else {
-		if c.c, err = cipher.NewGCMWithNonceSize(block, headerSize); err != nil {
-			return nil, err
-		}
 	}
-	return c, nil
+	return &crypto{aead: aead, auth: suite == AuthOnly, role: role, epoch: epoch & epochMask}, nil
 }
 
-func (c *crypto) encrypt(seg *packet.Packet) {
-	b := seg.AppendN(bytes).ReduceN(bytes).Bytes()
+func (c *crypto) nonce(r role, trailer []byte) []byte {
+	var nonce = make([]byte, c.aead.NonceSize())
+	nonce[0] = byte(r)
+	copy(nonce[len(nonce)-trailerBytes:], trailer)
+	return nonce
+}
+
+func (c *crypto) encrypt(seg *packet.Packet, headerSize int, mode Mode) error {
+	c.sent.Add(uint64(seg.Data() - headerSize))
+	if mode == Plain {
+		seg.Append(byte(Plain)<<6, 0, 0, 0, 0, 0, 0, 0)
+		return nil
+	}
+
+	counter := c.counter.Add(1)
+	if counter > maxCounter {
+		return errors.New("crypto counter exhausted")
+	}
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(byte(mode)<<6|c.epoch)<<56|counter)
 
-	i := c.headerSize
-	c.c.Seal(b[i:i], b[:i], b[i:], nil)
+	b := seg.AppendN(bytes + trailerBytes).ReduceN(bytes + trailerBytes).Bytes()
+	i := headerSize
+	if c.auth || mode == Authenticate {
+		c.aead.Seal(b[len(b):len(b)], c.nonce(c.role, trailer), nil, b)
+	} else {
+		c.aead.Seal(b[i:i], c.nonce(c.role, trailer), b[i:], b[:i])
+	}
 	seg.SetData(seg.Data() + bytes)
+	seg.Append(trailer...)
+	return nil
 }
 
-func (c *crypto) decrypt(seg *packet.Packet) error {
+func (c *crypto) decrypt(seg *packet.Packet, headerSize int) error {
 	b := seg.Bytes()
-	if len(b) < c.headerSize+bytes {
+	if len(b) < headerSize+trailerBytes {
+		return errors.New("decrypt invalid packet")
+	}
+	trailer := b[len(b)-trailerBytes:]
+	mode := Mode(trailer[0] >> 6)
+	if mode == Plain {
+		seg.SetData(seg.Data() - trailerBytes)
+		return nil
+	} else if len(b) < headerSize+bytes+trailerBytes {
 		return errors.New("decrypt invalid packet")
 	}
+	counter := binary.BigEndian.Uint64(trailer) & maxCounter
+	b = b[:len(b)-trailerBytes]
+	if !c.replay.check(counter) {
+		return errorx.WrapTemp(errors.Errorf("replay packet counter %d", counter))
+	}
 
-	i := c.headerSize
-	_, err := c.c.Open(b[i:i], b[:i], b[i:], nil)
+	var peer = server
+	if c.role.Server() {
+		peer = client
+	}
+	i := headerSize
+	var err error
+	if c.auth || mode == Authenticate {
+		n := len(b) - bytes
+		_, err = c.aead.Open(nil, c.nonce(peer, trailer), b[n:], b[:n])
+	} else {
+		_, err = c.aead.Open(b[i:i], c.nonce(peer, trailer), b[i:], b[:i])
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	seg.SetData(seg.Data() - bytes)
+	if !c.replay.accept(counter) {
+		return errorx.WrapTemp(errors.Errorf("replay packet counter %d", counter))
+	}
+	seg.SetData(seg.Data() - bytes - trailerBytes)
 
 	return nil
 }
+
+// trailerOf get packet's protection mode and key epoch
+func trailerOf(seg *packet.Packet) (Mode, uint8, error) {
+	b := seg.Bytes()
+	if len(b) < trailerBytes {
+		return 0, 0, errors.New("decrypt invalid packet")
+	}
+	t := b[len(b)-trailerBytes]
+	return Mode(t >> 6), t & epochMask, nil
+}
+
+const (
+	replayWords = 32
+	// replayWindow the last word maybe partial, see rfc 6479
+	replayWindow = (replayWords - 1) * 64
+)
+
+// replay sliding window anti-replay filter, rfc 6479
+type replay struct {
+	mu     sync.Mutex
+	last   uint64
+	bitmap [replayWords]uint64
+}
+
+// check counter not be received, not update window
+func (r *replay) check(counter uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.valid(counter)
+}
+
+// accept update window, should be called after counter be authenticated
+func (r *replay) accept(counter uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.valid(counter) {
+		return false
+	}
+
+	if counter > r.last {
+		cur, idx := r.last/64, counter/64
+		for i := uint64(1); i <= min(idx-cur, replayWords); i++ {
+			r.bitmap[(cur+i)%replayWords] = 0
+		}
+		r.last = counter
+	}
+	r.bitmap[(counter/64)%replayWords] |= 1 << (counter % 64)
+	return true
+}
+
+func (r *replay) valid(counter uint64) bool {
+	if counter == 0 {
+		return false
+	} else if counter > r.last {
+		return true
+	} else if r.last-counter >= replayWindow {
+		return false
+	}
+	return r.bitmap[(counter/64)%replayWords]&(1<<(counter%64)) == 0
+}
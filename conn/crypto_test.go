@@ -0,0 +1,95 @@
+package conn
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Crypto_Replay(t *testing.T) {
+	c, err := newCrypto(AES128GCM, key{1, 2, 3}, client, 0)
+	require.NoError(t, err)
+	s, err := newCrypto(AES128GCM, key{1, 2, 3}, server, 0)
+	require.NoError(t, err)
+	p := NewFamilyPeer().Reset(header.UDPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+
+	seal := func(msg string) []byte {
+		pkt := packet.Make(64, 0, 64).Append([]byte(msg)...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, c.encrypt(pkt, p.Overhead(), Encrypt))
+		return pkt.Bytes()
+	}
+	open := func(b []byte) error {
+		pkt := packet.Make(0, 0, len(b)).Append(b...)
+		return s.decrypt(pkt, p.Overhead())
+	}
+
+	p1, p2 := seal("hello"), seal("hello")
+	require.NotEqual(t, p1, p2)
+
+	require.NoError(t, open(p2))
+	require.NoError(t, open(p1)) // out of order
+	require.True(t, errorx.Temporary(open(p1)))
+	require.True(t, errorx.Temporary(open(p2)))
+
+	// reflect packet to sender
+	pkt := packet.Make(0, 0, len(p1)).Append(p1...)
+	require.Error(t, c.decrypt(pkt, p.Overhead()))
+
+	// too old
+	var old = seal("old")
+	for i := 0; i < replayWindow; i++ {
+		seal("")
+	}
+	require.NoError(t, open(seal("new")))
+	require.True(t, errorx.Temporary(open(old)))
+}
+
+func Test_Replay(t *testing.T) {
+	var r replay
+	require.False(t, r.accept(0))
+	require.True(t, r.accept(1))
+	require.False(t, r.accept(1))
+	require.True(t, r.accept(100))
+	require.True(t, r.accept(50))
+	require.False(t, r.accept(50))
+
+	require.True(t, r.accept(100+replayWindow))
+	require.False(t, r.check(100))
+	require.True(t, r.check(101))
+	require.True(t, r.accept(1<<40))
+	require.False(t, r.check(100+replayWindow))
+}
+
+func Test_Crypto_Mode(t *testing.T) {
+	c, err := newKeychain(AES128GCM, key{1, 2, 3}, client, time.Second)
+	require.NoError(t, err)
+	s, err := newKeychain(AES128GCM, key{1, 2, 3}, server, time.Second)
+	require.NoError(t, err)
+	p := NewFamilyPeer().Reset(header.UDPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+
+	for _, mode := range []Mode{Encrypt, Authenticate, Plain} {
+		pkt := packet.Make(64, 0, 64).Append([]byte("hello")...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, c.encrypt(pkt, p.Overhead(), mode))
+		require.Equal(t, mode != Encrypt, string(pkt.Bytes()[p.Overhead():p.Overhead()+5]) == "hello", mode)
+
+		m, err := s.decrypt(pkt, p.Overhead())
+		require.NoError(t, err, mode)
+		require.Equal(t, mode, m)
+		require.Equal(t, "hello", string(pkt.Bytes()[p.Overhead():]), mode)
+	}
+
+	// mode is authenticated
+	pkt := packet.Make(64, 0, 64).Append([]byte("hello")...)
+	require.NoError(t, p.Encode(pkt))
+	require.NoError(t, c.encrypt(pkt, p.Overhead(), Authenticate))
+	pkt.Bytes()[len(pkt.Bytes())-trailerBytes] ^= byte(Authenticate^Encrypt) << 6
+	_, err = s.decrypt(pkt, p.Overhead())
+	require.Error(t, err)
+}
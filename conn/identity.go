@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+)
+
+// Identity peer identity verified by handshake, by tls certificate or noise
+// static key, and user authenticated by credential. it's zero value if peer
+// not be authenticated.
+type Identity struct {
+	// Subject certificate subject common name, or noise key name in KeyFile
+	Subject string
+	// SANs certificate subject alternative names, include dns names, emails,
+	// ip addresses and uris
+	SANs []string
+	// Fingerprint hex encoded sha256 of certificate or noise public key
+	Fingerprint string
+
+	// User client user authenticated by Credential, only be set by server,
+	// client's self user see Conn.User
+	User string
+}
+
+func (i Identity) Valid() bool { return i.Fingerprint != "" || i.User != "" }
+
+func (i Identity) String() string {
+	if !i.Valid() {
+		return "anonymous"
+	}
+	return fmt.Sprintf("{User:%s, Subject:%s, Fingerprint:%s}", i.User, i.Subject, i.Fingerprint)
+}
+
+func certIdentity(cert *x509.Certificate) Identity {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, e := range cert.IPAddresses {
+		sans = append(sans, e.String())
+	}
+	for _, e := range cert.URIs {
+		sans = append(sans, e.String())
+	}
+
+	return Identity{
+		Subject:     cert.Subject.CommonName,
+		SANs:        sans,
+		Fingerprint: fingerprint(cert.Raw),
+	}
+}
+
+// tlsIdentity only verified certificate be trusted, server should set
+// tls.Config.ClientAuth as tls.RequireAndVerifyClientCert for mutual tls
+func tlsIdentity(state tls.ConnectionState) Identity {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return Identity{}
+	}
+	return certIdentity(state.PeerCertificates[0])
+}
+
+func noiseIdentity(key *ecdh.PublicKey, name string) Identity {
+	return Identity{Subject: name, Fingerprint: fingerprint(key.Bytes())}
+}
+
+func fingerprint(b []byte) string {
+	fp := sha256.Sum256(b)
+	return hex.EncodeToString(fp[:])
+}
@@ -0,0 +1,79 @@
+package conn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func issue(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func Test_TLS_Identity(t *testing.T) {
+	ca, caCert := issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	srv, _ := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, ca.PrivateKey.(*ecdsa.PrivateKey))
+	cli, cliCert := issue(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		EmailAddresses: []string{"alice@example.com"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, ca.PrivateKey.(*ecdsa.PrivateKey))
+
+	a, b := net.Pipe()
+	s := tls.Server(b, &tls.Config{
+		Certificates: []tls.Certificate{srv},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	c := tls.Client(a, &tls.Config{
+		Certificates: []tls.Certificate{cli},
+		RootCAs:      pool,
+		ServerName:   "server",
+	})
+
+	var rets = make(chan error, 1)
+	go func() { rets <- c.Handshake() }()
+	require.NoError(t, s.Handshake())
+	require.NoError(t, <-rets)
+
+	id := tlsIdentity(s.ConnectionState())
+	require.True(t, id.Valid())
+	require.Equal(t, "alice", id.Subject)
+	require.Equal(t, []string{"alice@example.com"}, id.SANs)
+	require.Equal(t, certIdentity(cliCert).Fingerprint, id.Fingerprint)
+
+	require.Equal(t, "server", tlsIdentity(c.ConnectionState()).Subject)
+	require.False(t, tlsIdentity(tls.ConnectionState{}).Valid())
+}
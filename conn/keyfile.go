@@ -0,0 +1,96 @@
+package conn
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyFile allowed clients' Noise public keys, every line is a base64 encoded
+// Curve25519 public key and an optional name, line start with '#' is comment:
+//
+//	# alice's laptop
+//	3yCeKTE3Xwg7Ph8rRb0A5FJjBZ3kWSdYuIh1hD4o0TI= alice
+//
+// the file will be reloaded if it's modified.
+type KeyFile struct {
+	path string
+
+	mu   sync.Mutex
+	mod  time.Time
+	keys map[[32]byte]string
+}
+
+var _ KeyStore = (*KeyFile)(nil)
+
+func NewKeyFile(path string) (*KeyFile, error) {
+	var f = &KeyFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *KeyFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return errors.WithStack(err)
+	} else if info.ModTime().Equal(f.mod) {
+		return nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keys, err := parseKeys(b)
+	if err != nil {
+		return errors.WithMessage(err, f.path)
+	}
+	f.mod, f.keys = info.ModTime(), keys
+	return nil
+}
+
+// Lookup find the public key, the previous keys be used if reload failed
+func (f *KeyFile) Lookup(key *ecdh.PublicKey) (name string, has bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reload()
+
+	name, has = f.keys[[32]byte(key.Bytes())]
+	return name, has
+}
+
+func parseKeys(b []byte) (map[[32]byte]string, error) {
+	var keys = map[[32]byte]string{}
+	var s = bufio.NewScanner(strings.NewReader(string(b)))
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key, err := ParseNoiseKey(fields[0])
+		if err != nil {
+			return nil, errors.WithMessagef(err, "line %d", i)
+		}
+		keys[[32]byte(key.Bytes())] = strings.Join(fields[1:], " ")
+	}
+	return keys, errors.WithStack(s.Err())
+}
+
+// ParseNoiseKey parse base64 encoded Curve25519 public key
+func ParseNoiseKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	return key, errors.WithStack(err)
+}
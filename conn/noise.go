@@ -0,0 +1,330 @@
+package conn
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// NoiseConfig Noise IK handshake config, alternative of tls, peers authenticate
+// by static Curve25519 keys instead of certificate.
+type NoiseConfig struct {
+	// PrivateKey local static key
+	PrivateKey *ecdh.PrivateKey
+
+	// ServerKey server's static public key, client required
+	ServerKey *ecdh.PublicKey
+
+	// Clients allowed clients' static public key, server required, see KeyFile
+	Clients KeyStore
+}
+
+type KeyStore interface {
+	Lookup(key *ecdh.PublicKey) (name string, has bool)
+}
+
+// Noise_IK_25519_ChaChaPoly_SHA256, see https://noiseprotocol.org/noise.html
+//
+//	<- s
+//	...
+//	-> e, es, s, ss
+//	<- e, ee, se
+const noiseProtocol = "Noise_IK_25519_ChaChaPoly_SHA256"
+
+type noiseState struct {
+	ck, h [32]byte
+	k     *[32]byte
+	n     uint64
+}
+
+func newNoiseState(rs *ecdh.PublicKey) *noiseState {
+	var s = &noiseState{}
+	copy(s.h[:], noiseProtocol)
+	s.ck = s.h
+	s.mixHash(nil) // prologue
+	s.mixHash(rs.Bytes())
+	return s
+}
+
+func (s *noiseState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+func (s *noiseState) mixKey(ikm []byte) {
+	var k [32]byte
+	s.ck, k = noiseHKDF(s.ck[:], ikm)
+	s.k, s.n = &k, 0
+}
+
+func (s *noiseState) mixDH(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.mixKey(secret)
+	return nil
+}
+
+func (s *noiseState) encryptAndHash(dst, plaintext []byte) []byte {
+	i := len(dst)
+	if s.k == nil {
+		dst = append(dst, plaintext...)
+	} else {
+		dst = noiseSeal(s.k, s.n, dst, plaintext, s.h[:])
+		s.n++
+	}
+	s.mixHash(dst[i:])
+	return dst
+}
+
+func (s *noiseState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	var plaintext = ciphertext
+	if s.k != nil {
+		var err error
+		plaintext, err = noiseOpen(s.k, s.n, ciphertext, s.h[:])
+		if err != nil {
+			return nil, err
+		}
+		s.n++
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split get initiator and responder's send key
+func (s *noiseState) split() (initiator, responder [32]byte) {
+	return noiseHKDF(s.ck[:], nil)
+}
+
+func noiseHKDF(ck, ikm []byte) (out1, out2 [32]byte) {
+	temp := hmacSHA256(ck, ikm)
+	copy(out1[:], hmacSHA256(temp, []byte{1}))
+	copy(out2[:], hmacSHA256(temp, append(out1[:], 2)))
+	return out1, out2
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func noiseNonce(n uint64) []byte {
+	var nonce = make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+func noiseSeal(k *[32]byte, n uint64, dst, plaintext, ad []byte) []byte {
+	aead, _ := chacha20poly1305.New(k[:])
+	return aead.Seal(dst, noiseNonce(n), plaintext, ad)
+}
+
+func noiseOpen(k *[32]byte, n uint64, ciphertext, ad []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(k[:])
+	plaintext, err := aead.Open(nil, noiseNonce(n), ciphertext, ad)
+	return plaintext, errors.WithStack(err)
+}
+
+// noiseClient initiator handshake, return secure stream
+func noiseClient(conn net.Conn, cfg *NoiseConfig) (net.Conn, error) {
+	if cfg.PrivateKey == nil || cfg.ServerKey == nil {
+		return nil, errors.New("noise client require PrivateKey and ServerKey")
+	}
+	s := newNoiseState(cfg.ServerKey)
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	msg := e.PublicKey().Bytes()
+	s.mixHash(msg)
+	if err := s.mixDH(e, cfg.ServerKey); err != nil {
+		return nil, err
+	}
+	msg = s.encryptAndHash(msg, cfg.PrivateKey.PublicKey().Bytes())
+	if err := s.mixDH(cfg.PrivateKey, cfg.ServerKey); err != nil {
+		return nil, err
+	}
+	msg = s.encryptAndHash(msg, nil)
+	if err := writeNoiseMsg(conn, msg); err != nil {
+		return nil, err
+	}
+
+	msg, err = readNoiseMsg(conn)
+	if err != nil {
+		return nil, err
+	} else if len(msg) != 32+16 {
+		return nil, errors.New("invalid noise handshake message")
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:32])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.mixHash(msg[:32])
+	if err := s.mixDH(e, re); err != nil {
+		return nil, err
+	}
+	if err := s.mixDH(cfg.PrivateKey, re); err != nil {
+		return nil, err
+	}
+	if _, err = s.decryptAndHash(msg[32:]); err != nil {
+		return nil, err
+	}
+
+	send, recv := s.split()
+	return newNoiseConn(conn, send, recv, cfg.ServerKey, ""), nil
+}
+
+// noiseServer responder handshake, return secure stream
+func noiseServer(conn net.Conn, cfg *NoiseConfig) (net.Conn, error) {
+	if cfg.PrivateKey == nil || cfg.Clients == nil {
+		return nil, errors.New("noise server require PrivateKey and Clients")
+	}
+	s := newNoiseState(cfg.PrivateKey.PublicKey())
+
+	msg, err := readNoiseMsg(conn)
+	if err != nil {
+		return nil, err
+	} else if len(msg) != 32+48+16 {
+		return nil, errors.New("invalid noise handshake message")
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:32])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.mixHash(msg[:32])
+	if err := s.mixDH(cfg.PrivateKey, re); err != nil {
+		return nil, err
+	}
+	b, err := s.decryptAndHash(msg[32 : 32+48])
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := s.mixDH(cfg.PrivateKey, rs); err != nil {
+		return nil, err
+	}
+	if _, err = s.decryptAndHash(msg[32+48:]); err != nil {
+		return nil, err
+	}
+	name, has := cfg.Clients.Lookup(rs)
+	if !has {
+		return nil, errors.New("noise client key not authorized")
+	}
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	msg = e.PublicKey().Bytes()
+	s.mixHash(msg)
+	if err := s.mixDH(e, re); err != nil {
+		return nil, err
+	}
+	if err := s.mixDH(e, rs); err != nil {
+		return nil, err
+	}
+	msg = s.encryptAndHash(msg, nil)
+	if err := writeNoiseMsg(conn, msg); err != nil {
+		return nil, err
+	}
+
+	recv, send := s.split()
+	return newNoiseConn(conn, send, recv, rs, name), nil
+}
+
+// noise message framed as {length 2B} {message}
+func writeNoiseMsg(conn net.Conn, msg []byte) error {
+	_, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return errors.WithStack(err)
+}
+
+func readNoiseMsg(conn net.Conn) ([]byte, error) {
+	var n = make([]byte, 2)
+	if _, err := io.ReadFull(conn, n); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var msg = make([]byte, binary.BigEndian.Uint16(n))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return msg, nil
+}
+
+// noiseConn noise transport stream
+type noiseConn struct {
+	net.Conn
+	remote *ecdh.PublicKey
+	name   string // remote key name
+
+	wmu        sync.Mutex
+	send       [32]byte
+	sendNonce  uint64
+	rmu        sync.Mutex
+	recv       [32]byte
+	recvNonce  uint64
+	recvBuffer []byte
+}
+
+const maxNoisePayload = 0xffff - chacha20poly1305.Overhead
+
+func newNoiseConn(conn net.Conn, send, recv [32]byte, remote *ecdh.PublicKey, name string) *noiseConn {
+	return &noiseConn{Conn: conn, send: send, recv: recv, remote: remote, name: name}
+}
+
+func (c *noiseConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.recvBuffer) == 0 {
+		msg, err := readNoiseMsg(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		c.recvBuffer, err = noiseOpen(&c.recv, c.recvNonce, msg, nil)
+		if err != nil {
+			return 0, err
+		}
+		c.recvNonce++
+	}
+	n := copy(b, c.recvBuffer)
+	c.recvBuffer = c.recvBuffer[n:]
+	return n, nil
+}
+
+func (c *noiseConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var n int
+	for len(b) > 0 {
+		m := min(len(b), maxNoisePayload)
+		msg := noiseSeal(&c.send, c.sendNonce, nil, b[:m], nil)
+		c.sendNonce++
+		if err := writeNoiseMsg(c.Conn, msg); err != nil {
+			return n, err
+		}
+		n, b = n+m, b[m:]
+	}
+	return n, nil
+}
+
+// RemoteKey peer's static public key
+func (c *noiseConn) RemoteKey() *ecdh.PublicKey { return c.remote }
+
+func (c *noiseConn) Identity() Identity { return noiseIdentity(c.remote, c.name) }
@@ -0,0 +1,121 @@
+package conn
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func noiseKey(t *testing.T) *ecdh.PrivateKey {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func keyFile(t *testing.T, keys ...*ecdh.PrivateKey) string {
+	var b []byte
+	b = append(b, "# clients\n"...)
+	for _, e := range keys {
+		b = append(b, base64.StdEncoding.EncodeToString(e.PublicKey().Bytes())+" name\n"...)
+	}
+	path := filepath.Join(t.TempDir(), "clients")
+	require.NoError(t, os.WriteFile(path, b, 0600))
+	return path
+}
+
+func Test_Noise_Handshake(t *testing.T) {
+	var (
+		ckey, skey = noiseKey(t), noiseKey(t)
+		a, b       = net.Pipe()
+	)
+	clients, err := NewKeyFile(keyFile(t, ckey))
+	require.NoError(t, err)
+
+	var rets = make(chan error, 1)
+	var sconn net.Conn
+	go func() {
+		var err error
+		sconn, err = noiseServer(b, &NoiseConfig{PrivateKey: skey, Clients: clients})
+		rets <- err
+	}()
+	cconn, err := noiseClient(a, &NoiseConfig{PrivateKey: ckey, ServerKey: skey.PublicKey()})
+	require.NoError(t, err)
+	require.NoError(t, <-rets)
+	require.True(t, sconn.(*noiseConn).RemoteKey().Equal(ckey.PublicKey()))
+	require.Equal(t, "name", sconn.(*noiseConn).Identity().Subject)
+
+	var msg = make([]byte, 0xffff*2)
+	rand.Read(msg)
+	go func() {
+		_, err := cconn.Write(msg)
+		rets <- err
+	}()
+	var buff = make([]byte, len(msg))
+	_, err = io.ReadFull(sconn, buff)
+	require.NoError(t, err)
+	require.NoError(t, <-rets)
+	require.Equal(t, msg, buff)
+
+	go func() {
+		_, err := sconn.Write([]byte("hello"))
+		rets <- err
+	}()
+	n, err := cconn.Read(buff)
+	require.NoError(t, err)
+	require.NoError(t, <-rets)
+	require.Equal(t, "hello", string(buff[:n]))
+}
+
+func Test_Noise_Unauthorized(t *testing.T) {
+	var (
+		ckey, skey = noiseKey(t), noiseKey(t)
+		a, b       = net.Pipe()
+	)
+	clients, err := NewKeyFile(keyFile(t, noiseKey(t)))
+	require.NoError(t, err)
+
+	var rets = make(chan error, 1)
+	go func() {
+		_, err := noiseServer(b, &NoiseConfig{PrivateKey: skey, Clients: clients})
+		b.Close()
+		rets <- err
+	}()
+	_, err = noiseClient(a, &NoiseConfig{PrivateKey: ckey, ServerKey: skey.PublicKey()})
+	require.Error(t, err)
+	require.Error(t, <-rets)
+}
+
+func Test_KeyFile(t *testing.T) {
+	var k1, k2 = noiseKey(t), noiseKey(t)
+	path := keyFile(t, k1)
+
+	f, err := NewKeyFile(path)
+	require.NoError(t, err)
+	name, has := f.Lookup(k1.PublicKey())
+	require.True(t, has)
+	require.Equal(t, "name", name)
+	_, has = f.Lookup(k2.PublicKey())
+	require.False(t, has)
+
+	// reload after modified
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(k2.PublicKey().Bytes())), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	_, has = f.Lookup(k1.PublicKey())
+	require.False(t, has)
+	_, has = f.Lookup(k2.PublicKey())
+	require.True(t, has)
+
+	_, err = NewKeyFile(keyFile(t))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0600))
+	_, err = NewKeyFile(path)
+	require.Error(t, err)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
@@ -58,7 +59,20 @@ func (p *defaultSession) IsBuiltin() bool {
 func (p *defaultSession) Overhead() int { return 5 }
 func (p *defaultSession) Valid() bool {
 	return p != nil && p.dst.IsValid() && p.dst.Is4() &&
-		(p.proto == tcp.ProtocolNumber || p.proto == udp.ProtocolNumber)
+		(p.proto == tcp.ProtocolNumber || p.proto == udp.ProtocolNumber || p.proto == header.ICMPv4ProtocolNumber || IsGeneric(p.proto))
+}
+
+// IsGeneric generic ip protocol without port, e.g. gre/sctp/esp, the payload
+// is forwarded as is, so a server address can only be used by one client.
+func IsGeneric(proto tcpip.TransportProtocolNumber) bool {
+	switch proto {
+	case tcp.ProtocolNumber, udp.ProtocolNumber, header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		return false
+	case 0, 43, 44, 59, 60: // ipv6 extension header
+		return false
+	default:
+		return proto <= 0xff
+	}
 }
 
 func (p *defaultSession) String() string {
@@ -71,6 +85,16 @@ func (p *defaultSession) String() string {
 		proto = "tcp"
 	case udp.ProtocolNumber:
 		proto = "udp"
+	case header.ICMPv4ProtocolNumber:
+		proto = "icmp"
+	case header.ICMPv6ProtocolNumber:
+		proto = "icmp6"
+	case 47:
+		proto = "gre"
+	case 50:
+		proto = "esp"
+	case 132:
+		proto = "sctp"
 	default:
 		proto = fmt.Sprintf("unknown(%d)", p.proto)
 	}
@@ -86,12 +110,7 @@ func (p *defaultSession) Encode(pkt *packet.Packet) error {
 	}
 
 	pkt.Attach(p.dst.AsSlice()...)
-	switch p.proto {
-	case tcp.ProtocolNumber, udp.ProtocolNumber:
-		pkt.Attach(byte(p.proto))
-	default:
-		return errors.Errorf("not support protocol %d", p.proto)
-	}
+	pkt.Attach(byte(p.proto))
 	return nil
 }
 
@@ -110,3 +129,104 @@ func (p *defaultSession) Decode(seg *packet.Packet) (err error) {
 	seg.SetHead(seg.Head() + 5)
 	return nil
 }
+
+// Family address family aware Peer, support ipv4 and ipv6 destination,
+// encoded as {proto} {ip version} {ipv4/ipv6 address}, overhead is 6 or 18
+// bytes. Default is the legacy 5 bytes ipv4 only format.
+type Family = *familySession
+
+var _ Peer = (Family)(nil)
+
+func NewFamilyPeer() Family {
+	return &familySession{}
+}
+
+type familySession struct {
+	proto tcpip.TransportProtocolNumber
+	dst   netip.Addr
+}
+
+func (p *familySession) Reset(proto tcpip.TransportProtocolNumber, remote netip.Addr) Peer {
+	p.proto, p.dst = proto, remote.Unmap()
+	return p
+}
+func (p *familySession) Protocol() tcpip.TransportProtocolNumber { return p.proto }
+func (p *familySession) Peer() netip.Addr                        { return p.dst }
+
+func (p *familySession) Builtin() Peer {
+	return &familySession{dst: netip.IPv4Unspecified(), proto: tcp.ProtocolNumber}
+}
+func (p *familySession) IsBuiltin() bool {
+	return p.Valid() && p.dst.Is4() && p.dst.IsUnspecified() && p.proto == tcp.ProtocolNumber
+}
+func (p *familySession) Overhead() int {
+	if p != nil && p.dst.Is6() {
+		return 2 + 16
+	}
+	return 2 + 4
+}
+func (p *familySession) Valid() bool {
+	if p == nil || !p.dst.IsValid() {
+		return false
+	}
+	switch p.proto {
+	case tcp.ProtocolNumber, udp.ProtocolNumber:
+		return true
+	case header.ICMPv4ProtocolNumber:
+		return p.dst.Is4()
+	case header.ICMPv6ProtocolNumber:
+		return p.dst.Is6()
+	default:
+		return IsGeneric(p.proto)
+	}
+}
+
+func (p *familySession) String() string {
+	if p == nil {
+		return "nil"
+	}
+	return (&defaultSession{proto: p.proto, dst: p.dst}).String()
+}
+
+func (p *familySession) Encode(pkt *packet.Packet) error {
+	if !p.Valid() {
+		return errors.Errorf("encode from invalid Peer: %s", p.String())
+	}
+
+	pkt.Attach(p.dst.AsSlice()...)
+	if p.dst.Is4() {
+		pkt.Attach(4)
+	} else {
+		pkt.Attach(6)
+	}
+	pkt.Attach(byte(p.proto))
+	return nil
+}
+
+func (p *familySession) Decode(seg *packet.Packet) (err error) {
+	if p == nil {
+		return errors.New("decode to nil Peer")
+	}
+
+	b := seg.Bytes()
+	if len(b) < 2 {
+		return errors.New("decode from invalid packet")
+	}
+	switch b[1] {
+	case 4:
+		if len(b) < 2+4 {
+			return errors.New("decode from invalid packet")
+		}
+		p.dst = netip.AddrFrom4([4]byte(b[2:6]))
+	case 6:
+		if len(b) < 2+16 {
+			return errors.New("decode from invalid packet")
+		}
+		p.dst = netip.AddrFrom16([16]byte(b[2:18]))
+	default:
+		return errors.Errorf("decode invalid ip version %d", b[1])
+	}
+	p.proto = tcpip.TransportProtocolNumber(b[0])
+	seg.SetHead(seg.Head() + p.Overhead())
+	return nil
+}
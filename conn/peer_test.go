@@ -0,0 +1,70 @@
+package conn
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_FamilyPeer(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4", "2001:db8::1", "::ffff:1.2.3.4"} {
+		dst := netip.MustParseAddr(addr)
+		p := NewFamilyPeer().Reset(header.UDPProtocolNumber, dst)
+
+		pkt := packet.Make(64, 0).Append([]byte("hello")...)
+		require.NoError(t, p.Encode(pkt))
+		require.Equal(t, p.Overhead()+5, len(pkt.Bytes()))
+
+		var q = NewFamilyPeer()
+		require.NoError(t, q.Decode(pkt))
+		require.Equal(t, dst.Unmap(), q.Peer())
+		require.Equal(t, header.UDPProtocolNumber, q.Protocol())
+		require.Equal(t, "hello", string(pkt.Bytes()))
+		require.False(t, q.IsBuiltin())
+	}
+
+	b := NewFamilyPeer().Builtin()
+	pkt := packet.Make(64, 0)
+	require.NoError(t, b.Encode(pkt))
+	var q = NewFamilyPeer()
+	require.NoError(t, q.Decode(pkt))
+	require.True(t, q.IsBuiltin())
+}
+
+func Test_IsGeneric(t *testing.T) {
+	for _, proto := range []tcpip.TransportProtocolNumber{47, 50, 132} {
+		require.True(t, IsGeneric(proto), proto)
+		require.True(t, NewDefaultPeer().Reset(proto, netip.MustParseAddr("1.2.3.4")).Valid(), proto)
+	}
+	// ipv6 extension header is not transport protocol
+	for _, proto := range []tcpip.TransportProtocolNumber{0, 43, 44, 59, 60, 256} {
+		require.False(t, IsGeneric(proto), proto)
+		require.False(t, NewDefaultPeer().Reset(proto, netip.MustParseAddr("1.2.3.4")).Valid(), proto)
+		require.False(t, NewFamilyPeer().Reset(proto, netip.MustParseAddr("2001:db8::1")).Valid(), proto)
+	}
+	require.False(t, IsGeneric(header.TCPProtocolNumber))
+}
+
+func Test_Crypto_Family(t *testing.T) {
+	c, err := newCrypto(AES128GCM, key{1, 2, 3}, client, 0)
+	require.NoError(t, err)
+	s, err := newCrypto(AES128GCM, key{1, 2, 3}, server, 0)
+	require.NoError(t, err)
+
+	for _, addr := range []string{"1.2.3.4", "2001:db8::1"} {
+		p := NewFamilyPeer().Reset(header.TCPProtocolNumber, netip.MustParseAddr(addr))
+		pkt := packet.Make(64, 0, 64).Append([]byte("hello")...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, c.encrypt(pkt, p.Overhead(), Encrypt))
+
+		var q = NewFamilyPeer()
+		require.NoError(t, q.Decode(pkt))
+		require.NoError(t, s.decrypt(pkt.AttachN(q.Overhead()), q.Overhead()))
+		pkt.DetachN(q.Overhead())
+		require.Equal(t, "hello", string(pkt.Bytes()))
+	}
+}
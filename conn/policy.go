@@ -0,0 +1,65 @@
+package conn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Mode data-plane packet protection mode, signalled in packet
+type Mode uint8
+
+const (
+	// Encrypt encrypt and authenticate packet, default
+	Encrypt Mode = iota
+
+	// Authenticate only authenticate packet, e.g. the flow is tls/quic
+	Authenticate
+
+	// Plain not protect packet, not resist tamper, replay and off-path injection
+	Plain
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Encrypt:
+		return "encrypt"
+	case Authenticate:
+		return "authenticate"
+	case Plain:
+		return "plain"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(m))
+	}
+}
+
+// Weaker m is weaker protection than the mode
+func (m Mode) Weaker(mode Mode) bool { return m > mode }
+
+// Policy decide the flow's protection mode, remote is the proxyed server address,
+// port is zero if protocol without port.
+type Policy func(proto tcpip.TransportProtocolNumber, remote netip.AddrPort) Mode
+
+// mode get the flow's protection mode of the data-plane packet
+func (c *conn) mode(peer Peer, payload []byte, send bool) Mode {
+	if c.config.Policy == nil {
+		return Encrypt
+	}
+
+	// client send and server recv uplink packet, the proxyed server port is dst port
+	var port uint16
+	switch peer.Protocol() {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if len(payload) >= 4 {
+			if send == c.role.Client() {
+				port = binary.BigEndian.Uint16(payload[2:4])
+			} else {
+				port = binary.BigEndian.Uint16(payload[0:2])
+			}
+		}
+	}
+	return c.config.Policy(peer.Protocol(), netip.AddrPortFrom(peer.Peer(), port))
+}
@@ -0,0 +1,31 @@
+package conn
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Conn_Mode(t *testing.T) {
+	var c = &conn{role: server, config: &Config{
+		Policy: func(proto tcpip.TransportProtocolNumber, remote netip.AddrPort) Mode {
+			if proto == header.TCPProtocolNumber && remote.Port() == 443 {
+				return Authenticate
+			}
+			return Encrypt
+		},
+	}}
+	p := NewFamilyPeer().Reset(header.TCPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+
+	// server recv uplink, dst port is proxyed server port
+	uplink := header.TCP(make([]byte, header.TCPMinimumSize))
+	uplink.Encode(&header.TCPFields{SrcPort: 19986, DstPort: 443})
+	require.Equal(t, Authenticate, c.mode(p, uplink, false))
+	require.Equal(t, Encrypt, c.mode(p, uplink, true))
+
+	require.True(t, Plain.Weaker(Authenticate))
+	require.False(t, Encrypt.Weaker(Authenticate))
+}
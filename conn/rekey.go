@@ -0,0 +1,250 @@
+package conn
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+)
+
+// keychain data-plane keys by epoch. rekey is launched by server: server install
+// new key and send it to client by builtin stream, client install the key and send
+// with it immediately, then ack server, server send with new key after received
+// ack. the old key still be accepted in grace period after switched. the rekey
+// frame, ack or client's request will be re-sent if not finished in timeout.
+type keychain struct {
+	suite   CipherSuite
+	role    role
+	grace   time.Duration
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	send    *crypto
+	recv    map[uint8]*crypto
+	expires map[uint8]time.Time
+	pending time.Time // server: wait ack; client: rekey requested; zero if not
+	next    key       // server: pending rekey's key
+}
+
+// rekeyTimeout re-send rekey frame or request if not finished
+const rekeyTimeout = time.Second * 10
+
+func newKeychain(suite CipherSuite, key key, role role, grace time.Duration) (*keychain, error) {
+	c, err := newCrypto(suite, key, role, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &keychain{
+		suite:   suite,
+		role:    role,
+		grace:   grace,
+		timeout: rekeyTimeout,
+		send:    c,
+		recv:    map[uint8]*crypto{0: c},
+		expires: map[uint8]time.Time{},
+	}, nil
+}
+
+func (k *keychain) sender() *crypto {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.send
+}
+
+func (k *keychain) receiver(epoch uint8) (*crypto, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	c, has := k.recv[epoch]
+	if !has {
+		return nil, false
+	} else if e, has := k.expires[epoch]; has && time.Now().After(e) {
+		return nil, false
+	}
+	return c, true
+}
+
+func (k *keychain) encrypt(seg *packet.Packet, headerSize int, mode Mode) error {
+	return k.sender().encrypt(seg, headerSize, mode)
+}
+
+// decrypt return the packet's protection mode
+func (k *keychain) decrypt(seg *packet.Packet, headerSize int) (Mode, error) {
+	mode, epoch, err := trailerOf(seg)
+	if err != nil {
+		return 0, err
+	}
+	c, has := k.receiver(epoch)
+	if mode == Plain {
+		c, has = k.sender(), true // not use key
+	}
+	if !has {
+		return 0, errorx.WrapTemp(errors.Errorf("invalid key epoch %d", epoch))
+	}
+	return mode, c.decrypt(seg, headerSize)
+}
+
+// install accept packet encrypted by the key
+func (k *keychain) install(key key, epoch uint8) error {
+	c, err := newCrypto(k.suite, key, k.role, epoch)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	for e, t := range k.expires {
+		if now.After(t) {
+			delete(k.recv, e)
+			delete(k.expires, e)
+		}
+	}
+	k.recv[epoch] = c
+	delete(k.expires, epoch)
+	return nil
+}
+
+// activate send with the epoch key, old key will expire after grace
+func (k *keychain) activate(epoch uint8) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c, has := k.recv[epoch]
+	if !has {
+		return errors.Errorf("not installed key epoch %d", epoch)
+	} else if c == k.send {
+		return nil
+	}
+
+	k.expires[k.send.epoch] = time.Now().Add(k.grace)
+	k.send, k.pending = c, time.Time{}
+	return nil
+}
+
+// stalled pending rekey not finished in timeout
+func (k *keychain) stalled() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !k.pending.IsZero() && time.Since(k.pending) > k.timeout
+}
+
+// rekey server launch rekey, return the new key, return false if previous
+// rekey not be acked, return the pending key again if it not acked in timeout
+func (k *keychain) rekey() (key key, epoch uint8, ok bool, err error) {
+	k.mu.Lock()
+	epoch = (k.send.epoch + 1) & epochMask
+	if !k.pending.IsZero() {
+		defer k.mu.Unlock()
+		if time.Since(k.pending) <= k.timeout {
+			return key, 0, false, nil
+		}
+		k.pending = time.Now()
+		return k.next, epoch, true, nil
+	}
+	k.pending = time.Now()
+	k.mu.Unlock()
+
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, 0, false, errors.WithStack(err)
+	}
+	k.mu.Lock()
+	k.next = key
+	k.mu.Unlock()
+	return key, epoch, true, k.install(key, epoch)
+}
+
+// request client request rekey, return false if requested and not timeout
+func (k *keychain) request() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.pending.IsZero() && time.Since(k.pending) <= k.timeout {
+		return false
+	}
+	k.pending = time.Now()
+	return true
+}
+
+// rekeyService server rekey periodically or sent bytes exceed, or client request
+func (c *conn) rekeyService() {
+	var ticker = time.NewTicker(min(c.config.rekeyInterval(), time.Second))
+	defer ticker.Stop()
+
+	var last = time.Now()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if time.Since(last) < c.config.rekeyInterval() &&
+				c.crypto.sender().sent.Load() < c.config.rekeyBytes() &&
+				!c.crypto.stalled() {
+				continue
+			}
+		case <-c.rekeyReq:
+		}
+
+		key, epoch, ok, err := c.crypto.rekey()
+		if err != nil {
+			c.close(err)
+			return
+		} else if !ok {
+			continue
+		}
+		if err := c.builtin.writeFrame(frameRekey, append([]byte{epoch}, key[:]...)); err != nil {
+			c.close(err)
+			return
+		}
+		last = time.Now()
+	}
+}
+
+// handleFrame handle builtin stream control frame
+func (c *conn) handleFrame(typ frameType, payload []byte) error {
+	if typ == frameClose && c.role.Client() {
+		return c.close(errors.Errorf("closed by server: %s", payload))
+	}
+	if c.crypto == nil {
+		return errors.Errorf("not support builtin frame type %d", typ)
+	}
+	switch typ {
+	case frameRekey:
+		if c.role.Server() || len(payload) != 1+len(key{}) {
+			return errors.Errorf("invalid rekey frame")
+		}
+		epoch := payload[0]
+		if c.crypto.sender().epoch == epoch {
+			// re-sent by server, previous ack lost
+			return c.builtin.writeFrame(frameRekeyAck, []byte{epoch})
+		}
+		if err := c.crypto.install(key(payload[1:]), epoch); err != nil {
+			return err
+		}
+		if err := c.crypto.activate(epoch); err != nil {
+			return err
+		}
+		return c.builtin.writeFrame(frameRekeyAck, []byte{epoch})
+	case frameRekeyAck:
+		if c.role.Client() || len(payload) != 1 {
+			return errors.Errorf("invalid rekey ack frame")
+		}
+		return c.crypto.activate(payload[0])
+	case frameTicket:
+		if c.role.Server() {
+			return errors.Errorf("invalid ticket frame")
+		}
+		return c.storeTicket(payload)
+	case frameRekeyReq:
+		if c.role.Client() {
+			return errors.Errorf("invalid rekey request frame")
+		}
+		select {
+		case c.rekeyReq <- struct{}{}:
+		default:
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown builtin frame type %d", typ)
+	}
+}
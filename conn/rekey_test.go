@@ -0,0 +1,111 @@
+package conn
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Keychain_Rekey(t *testing.T) {
+	const grace = time.Millisecond * 100
+	c, err := newKeychain(AES128GCM, key{1}, client, grace)
+	require.NoError(t, err)
+	s, err := newKeychain(AES128GCM, key{1}, server, grace)
+	require.NoError(t, err)
+	p := NewFamilyPeer().Reset(header.UDPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+
+	seal := func(k *keychain) []byte {
+		pkt := packet.Make(64, 0, 64).Append([]byte("hello")...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, k.encrypt(pkt, p.Overhead(), Encrypt))
+		return pkt.Bytes()
+	}
+	open := func(k *keychain, b []byte) error {
+		pkt := packet.Make(0, 0, len(b)).Append(b...)
+		_, err := k.decrypt(pkt, p.Overhead())
+		return err
+	}
+
+	old1, old2 := seal(c), seal(c)
+	key, epoch, ok, err := s.rekey()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint8(1), epoch)
+	_, _, ok, err = s.rekey()
+	require.NoError(t, err)
+	require.False(t, ok) // wait ack
+
+	// re-send pending key if not acked in timeout
+	s.timeout = time.Millisecond * 10
+	time.Sleep(s.timeout * 2)
+	require.True(t, s.stalled())
+	key2, epoch2, ok, err := s.rekey()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, key, key2)
+	require.Equal(t, epoch, epoch2)
+	require.False(t, s.stalled())
+
+	// client switch to new key, server send with old key until ack
+	require.NoError(t, c.install(key, epoch))
+	require.NoError(t, c.activate(epoch))
+	require.NoError(t, open(s, seal(c)))
+	require.NoError(t, open(c, seal(s)))
+
+	require.NoError(t, s.activate(epoch))
+	require.Equal(t, epoch, s.sender().epoch)
+	require.NoError(t, open(c, seal(s)))
+
+	// old key accepted in grace period
+	require.NoError(t, open(s, old1))
+	time.Sleep(grace * 2)
+	require.True(t, errorx.Temporary(open(s, old2)))
+}
+
+func Test_Keychain_Request(t *testing.T) {
+	c, err := newKeychain(AES128GCM, key{1}, client, time.Second)
+	require.NoError(t, err)
+	c.timeout = time.Millisecond * 10
+
+	require.True(t, c.request())
+	require.False(t, c.request())
+	time.Sleep(c.timeout * 2)
+	require.True(t, c.request(), "re-request after timeout")
+
+	require.NoError(t, c.install(key{2}, 1))
+	require.NoError(t, c.activate(1))
+	require.True(t, c.request())
+}
+
+func Test_Builtin_Frame(t *testing.T) {
+	a, b := net.Pipe()
+
+	var frames = make(chan []byte, 1)
+	ba := newBuiltin(a, func(typ frameType, payload []byte) error {
+		return nil
+	})
+	defer ba.Close()
+	bb := newBuiltin(b, func(typ frameType, payload []byte) error {
+		require.Equal(t, frameRekeyReq, typ)
+		frames <- append([]byte{}, payload...)
+		return nil
+	})
+	defer bb.Close()
+
+	go func() {
+		ba.user.Write([]byte("hello"))
+		ba.writeFrame(frameRekeyReq, []byte{1})
+	}()
+
+	var buff = make([]byte, 64)
+	n, err := bb.user.Read(buff)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buff[:n]))
+	require.Equal(t, []byte{1}, <-frames)
+}
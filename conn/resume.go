@@ -0,0 +1,388 @@
+package conn
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Resumption session resumption by ticket. server issue ticket by builtin stream
+// after handshake, reconnecting client restore the session (identity and
+// authenticated user) and negotiate data-plane key in one round trip, without
+// tls/noise handshake and authenticate. ticket is single use and data-plane key
+// always be regenerated, so the resumed session can't be replayed. one instance
+// can be shared by all conns of the process.
+type Resumption struct {
+	// Lifetime ticket lifetime, default 12 hours
+	Lifetime time.Duration
+	// MaxAge max duration since the full handshake, the resumed session's ticket
+	// not be reissued after it, client must full handshake and authenticate
+	// again. default 24 hours
+	MaxAge time.Duration
+
+	once sync.Once
+	aead cipher.AEAD // server: seal ticket by random key
+	err  error
+
+	mu       sync.Mutex
+	used     map[[16]byte]time.Time // server: used ticket id until expiry
+	sessions map[string]session     // client: by server address
+}
+
+func (r *Resumption) lifetime() time.Duration {
+	if r.Lifetime <= 0 {
+		return time.Hour * 12
+	}
+	return r.Lifetime
+}
+func (r *Resumption) maxAge() time.Duration {
+	if r.MaxAge <= 0 {
+		return time.Hour * 24
+	}
+	return r.MaxAge
+}
+
+// SessionID server session id, allocated by full handshake and kept by resumed
+// conn, so server can recognize a reconnected client by the ticket, instead of
+// trust it's address or identity.
+type SessionID [16]byte
+
+func (id SessionID) String() string { return hex.EncodeToString(id[:]) }
+
+type session struct {
+	ticket   []byte
+	secret   key
+	identity Identity
+	user     string
+	expiry   time.Time
+}
+
+// ticketState sealed in ticket, only server can open. not include client's
+// credential, the authenticated user (Identity.User) be rechecked by name and
+// credential version, client certificate chain be re-verified.
+type ticketState struct {
+	ID          [16]byte
+	Session     SessionID
+	Expiry      time.Time
+	AuthTime    time.Time // full handshake time, see Resumption.MaxAge
+	Secret      key
+	Identity    Identity
+	CredVersion string
+	Certs       [][]byte // tls client certificate chain
+	NoiseKey    []byte   // noise client static key
+}
+
+func (r *Resumption) init() error {
+	r.once.Do(func() {
+		var k = make([]byte, chacha20poly1305.KeySize)
+		if _, r.err = rand.Read(k); r.err != nil {
+			return
+		}
+		r.aead, r.err = chacha20poly1305.NewX(k)
+		r.used = map[[16]byte]time.Time{}
+		r.sessions = map[string]session{}
+	})
+	return errors.WithStack(r.err)
+}
+
+func (r *Resumption) seal(st *ticketState) ([]byte, error) {
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var nonce = make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return r.aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// open validate ticket and mark it used
+func (r *Resumption) open(ticket []byte) (*ticketState, error) {
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	n := r.aead.NonceSize()
+	if len(ticket) < n {
+		return nil, errors.New("invalid ticket")
+	}
+	b, err := r.aead.Open(nil, ticket[:n], ticket[n:], nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var st = &ticketState{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, t := range r.used {
+		if now.After(t) {
+			delete(r.used, id)
+		}
+	}
+	if now.After(st.Expiry) {
+		return nil, errors.New("ticket expired")
+	} else if _, has := r.used[st.ID]; has {
+		return nil, errors.New("ticket replayed")
+	}
+	r.used[st.ID] = st.Expiry
+	return st, nil
+}
+
+func (r *Resumption) store(addr string, s session) error {
+	if err := r.init(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[addr] = s
+	return nil
+}
+
+// take client take out session, ticket is single use
+func (r *Resumption) take(addr string) (session, bool) {
+	if r.init() != nil {
+		return session{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, has := r.sessions[addr]
+	delete(r.sessions, addr)
+	if !has || time.Now().After(s.expiry) {
+		return session{}, false
+	}
+	return s, true
+}
+
+const (
+	handshakeFull uint8 = iota
+	handshakeResume
+)
+
+// resume try resume session before tls/noise handshake, the secure stream key
+// derived from ticket secret and the hello, return nil secure conn if full
+// handshake required:
+//
+//	client -> server: {mode} [{len 2B} {ticket} {nonce 32B} {count} {suites...}]
+//	server -> client: [{ok}], secure stream: {suite} {key}
+func (c *conn) resume(tcp net.Conn) (secure net.Conn, suite CipherSuite, key key, err error) {
+	if c.role.Client() {
+		var sess session
+		var has bool
+		if c.config.Resumption != nil {
+			sess, has = c.config.Resumption.take(c.RemoteAddr().String())
+		}
+		if !has {
+			_, err = tcp.Write([]byte{handshakeFull})
+			return nil, 0, key, errors.WithStack(err)
+		}
+
+		var hello = []byte{handshakeResume}
+		hello = binary.BigEndian.AppendUint16(hello, uint16(len(sess.ticket)))
+		hello = append(hello, sess.ticket...)
+		hello = append(hello, make([]byte, 32)...)
+		if _, err = rand.Read(hello[len(hello)-32:]); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		}
+		hello = appendSuites(hello, c.config.cipherSuites())
+		if _, err = tcp.Write(hello); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		}
+
+		var ok = make([]byte, 1)
+		if _, err = io.ReadFull(tcp, ok); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		} else if ok[0] == 0 {
+			return nil, 0, key, nil // fallback full handshake
+		}
+
+		send, recv := resumeKeys(sess.secret, hello)
+		secure = newNoiseConn(tcp, send, recv, nil, "")
+		if suite, key, err = c.selected(secure); err != nil {
+			return nil, 0, key, err
+		}
+		c.identity, c.user = sess.identity, sess.user
+		return secure, suite, key, nil
+	} else {
+		var mode = make([]byte, 1)
+		if _, err = io.ReadFull(tcp, mode); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		} else if mode[0] == handshakeFull {
+			return nil, 0, key, nil
+		} else if mode[0] != handshakeResume {
+			return nil, 0, key, errors.Errorf("invalid handshake mode %d", mode[0])
+		}
+
+		ticket, err := readString(tcp)
+		if err != nil {
+			return nil, 0, key, err
+		}
+		var nonce = make([]byte, 32)
+		if _, err = io.ReadFull(tcp, nonce); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		}
+		suites, err := readSuites(tcp)
+		if err != nil {
+			return nil, 0, key, err
+		}
+
+		st, err := c.restore([]byte(ticket))
+		if err != nil {
+			_, err = tcp.Write([]byte{0})
+			return nil, 0, key, errors.WithStack(err)
+		}
+		if _, err = tcp.Write([]byte{1}); err != nil {
+			return nil, 0, key, errors.WithStack(err)
+		}
+
+		var hello = []byte{handshakeResume}
+		hello = appendString(hello, ticket)
+		hello = append(hello, nonce...)
+		hello = appendSuites(hello, suites)
+		recv, send := resumeKeys(st.Secret, hello)
+		secure = newNoiseConn(tcp, send, recv, nil, "")
+		if suite, key, err = c.selectKey(secure, suites); err != nil {
+			return nil, 0, key, err
+		}
+		c.identity, c.credVersion, c.noiseKey = st.Identity, st.CredVersion, st.NoiseKey
+		c.session, c.authTime, c.certs = st.Session, st.AuthTime, st.Certs
+		return secure, suite, key, nil
+	}
+}
+
+// restore open ticket, and recheck the client still be authorized
+func (c *conn) restore(ticket []byte) (*ticketState, error) {
+	if c.config.Resumption == nil {
+		return nil, errors.New("not support resumption")
+	}
+	st, err := c.config.Resumption.open(ticket)
+	if err != nil {
+		return nil, err
+	} else if time.Since(st.AuthTime) > c.config.Resumption.maxAge() {
+		return nil, errors.New("session too old")
+	}
+
+	if c.config.Users != nil {
+		if err := c.config.Users.Check(st.Identity.User, st.CredVersion); err != nil {
+			return nil, err
+		}
+	}
+	if c.config.Noise != nil && c.config.TLS == nil {
+		key, err := ecdh.X25519().NewPublicKey(st.NoiseKey)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		} else if _, has := c.config.Noise.Clients.Lookup(key); !has {
+			return nil, errors.New("noise client key not authorized")
+		}
+	}
+	if len(st.Certs) > 0 {
+		if err := verifyClientCerts(c.config.TLS, st.Certs); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// verifyClientCerts re-verify client certificate chain by current tls config,
+// the certificate maybe expired or the CA be rotated after full handshake
+func verifyClientCerts(config *tls.Config, rawCerts [][]byte) error {
+	if config == nil {
+		return errors.New("not support tls")
+	}
+	var opts = x509.VerifyOptions{
+		Roots:         config.ClientCAs,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	var certs []*x509.Certificate
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		certs = append(certs, cert)
+	}
+	for _, e := range certs[1:] {
+		opts.Intermediates.AddCert(e)
+	}
+	_, err := certs[0].Verify(opts)
+	return errors.WithStack(err)
+}
+
+// resumeKeys client and server send key, the hello be bound to avoid tamper
+func resumeKeys(secret key, hello []byte) (client, server [32]byte) {
+	h := sha256.Sum256(hello)
+	return noiseHKDF(secret[:], h[:])
+}
+
+// issueTicket server issue ticket for next reconnect, the expiry not exceed
+// Resumption.MaxAge since full handshake
+//
+//	{expiry 8B} {secret} {ticket}
+func (c *conn) issueTicket() error {
+	var st = &ticketState{
+		Session:     c.session,
+		Expiry:      time.Now().Add(c.config.Resumption.lifetime()),
+		AuthTime:    c.authTime,
+		Identity:    c.identity,
+		CredVersion: c.credVersion,
+		Certs:       c.certs,
+		NoiseKey:    c.noiseKey,
+	}
+	if limit := c.authTime.Add(c.config.Resumption.maxAge()); st.Expiry.After(limit) {
+		st.Expiry = limit
+	}
+	if time.Until(st.Expiry) <= 0 {
+		return nil // require full handshake
+	}
+	if _, err := rand.Read(st.ID[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := rand.Read(st.Secret[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	ticket, err := c.config.Resumption.seal(st)
+	if err != nil {
+		return err
+	}
+
+	b := binary.BigEndian.AppendUint64(nil, uint64(st.Expiry.Unix()))
+	b = append(b, st.Secret[:]...)
+	b = append(b, ticket...)
+	return c.builtin.writeFrame(frameTicket, b)
+}
+
+// storeTicket client store ticket for next reconnect
+func (c *conn) storeTicket(payload []byte) error {
+	if len(payload) <= 8+len(key{}) {
+		return errors.New("invalid ticket frame")
+	} else if c.config.Resumption == nil {
+		return nil
+	}
+	return c.config.Resumption.store(c.RemoteAddr().String(), session{
+		ticket:   append([]byte{}, payload[8+len(key{}):]...),
+		secret:   key(payload[8:]),
+		identity: c.identity,
+		user:     c.user,
+		expiry:   time.Unix(int64(binary.BigEndian.Uint64(payload)), 0),
+	})
+}
@@ -0,0 +1,192 @@
+package conn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Resume(t *testing.T) {
+	raddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:19986")
+	require.NoError(t, err)
+	dgram, err := net.DialUDP("udp", nil, raddr)
+	require.NoError(t, err)
+	defer dgram.Close()
+
+	users, err := NewUserFile(userFile(t))
+	require.NoError(t, err)
+	_, version, err := users.Authenticate(Credential{Username: "alice", Password: "123456"})
+	require.NoError(t, err)
+
+	var (
+		cres, sres = &Resumption{}, &Resumption{}
+		cli        = &conn{role: client, conn: dgram, config: &Config{Resumption: cres}, user: "alice"}
+		srv        = &conn{role: server, config: &Config{Resumption: sres, Users: users}, session: SessionID{1},
+			identity: Identity{User: "alice"}, credVersion: version, authTime: time.Now()}
+	)
+
+	// issue ticket
+	cli.crypto, err = newKeychain(AES128GCM, key{1}, client, time.Second)
+	require.NoError(t, err)
+	a, b := net.Pipe()
+	cli.builtin = newBuiltin(a, cli.handleFrame)
+	defer cli.builtin.Close()
+	srv.builtin = newBuiltin(b, srv.handleFrame)
+	defer srv.builtin.Close()
+	require.NoError(t, srv.issueTicket())
+	require.Eventually(t, func() bool {
+		cres.mu.Lock()
+		defer cres.mu.Unlock()
+		return len(cres.sessions) == 1
+	}, time.Second, time.Millisecond*10)
+	cres.mu.Lock()
+	sess := cres.sessions[dgram.RemoteAddr().String()]
+	cres.mu.Unlock()
+
+	resume := func(c, s *conn) (net.Conn, net.Conn, CipherSuite, key) {
+		a, b := net.Pipe()
+		type ret struct {
+			conn  net.Conn
+			suite CipherSuite
+			key   key
+			err   error
+		}
+		var rets = make(chan ret, 1)
+		go func() {
+			conn, suite, key, err := s.resume(b)
+			rets <- ret{conn, suite, key, err}
+		}()
+		cconn, suite, key, err := c.resume(a)
+		require.NoError(t, err)
+		r := <-rets
+		require.NoError(t, r.err)
+		if cconn != nil {
+			require.Equal(t, suite, r.suite)
+			require.Equal(t, key, r.key)
+		}
+		return cconn, r.conn, suite, key
+	}
+
+	c2 := &conn{role: client, conn: dgram, config: &Config{Resumption: cres}}
+	s2 := &conn{role: server, config: &Config{Resumption: sres, Users: users}}
+	cconn, sconn, _, key1 := resume(c2, s2)
+	require.NotNil(t, cconn)
+	require.NotNil(t, sconn)
+	require.Equal(t, "alice", s2.identity.User)
+	require.Equal(t, "alice", c2.User())
+	require.Equal(t, srv.Session(), s2.Session(), "resumed session")
+
+	// ticket is single use
+	cconn, sconn, _, _ = resume(c2, s2)
+	require.Nil(t, cconn)
+	require.Nil(t, sconn)
+
+	// replay
+	require.NoError(t, cres.store(dgram.RemoteAddr().String(), sess))
+	cconn, sconn, _, _ = resume(c2, s2)
+	require.Nil(t, cconn)
+	require.Nil(t, sconn)
+
+	// new data-plane key every resume
+	require.NoError(t, srv.issueTicket())
+	require.Eventually(t, func() bool {
+		cres.mu.Lock()
+		defer cres.mu.Unlock()
+		return len(cres.sessions) == 1
+	}, time.Second, time.Millisecond*10)
+	cconn, _, _, key2 := resume(c2, s2)
+	require.NotNil(t, cconn)
+	require.NotEqual(t, key1, key2)
+
+	// ticket expiry not exceed max age since full handshake
+	srv.authTime = time.Now().Add(-sres.maxAge() + time.Minute)
+	require.NoError(t, srv.issueTicket())
+	require.Eventually(t, func() bool {
+		cres.mu.Lock()
+		defer cres.mu.Unlock()
+		return len(cres.sessions) == 1
+	}, time.Second, time.Millisecond*10)
+	cres.mu.Lock()
+	require.WithinDuration(t, srv.authTime.Add(sres.maxAge()), cres.sessions[dgram.RemoteAddr().String()].expiry, time.Second)
+	cres.mu.Unlock()
+	cconn, _, _, _ = resume(c2, s2)
+	require.NotNil(t, cconn)
+	require.True(t, srv.authTime.Equal(s2.authTime), "keep full handshake time")
+
+	srv.authTime = time.Now().Add(-sres.maxAge())
+	require.NoError(t, srv.issueTicket())
+	cres.mu.Lock()
+	require.Empty(t, cres.sessions, "not issue ticket")
+	cres.mu.Unlock()
+	srv.authTime = time.Now()
+
+	// user's credential changed can't resume
+	issue := func() {
+		require.NoError(t, srv.issueTicket())
+		require.Eventually(t, func() bool {
+			cres.mu.Lock()
+			defer cres.mu.Unlock()
+			return len(cres.sessions) == 1
+		}, time.Second, time.Millisecond*10)
+	}
+	issue()
+	hash, err := HashPassword("654321")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(users.path, []byte("alice password "+hash+"\n"), 0600))
+	require.NoError(t, os.Chtimes(users.path, time.Now(), time.Now().Add(time.Second)))
+	cconn, _, _, _ = resume(c2, s2)
+	require.Nil(t, cconn)
+
+	// revoked user can't resume
+	issue()
+	require.NoError(t, os.WriteFile(users.path, []byte("alice token "+HashToken("alice-token")+" revoked\n"), 0600))
+	require.NoError(t, os.Chtimes(users.path, time.Now(), time.Now().Add(time.Second*2)))
+	cconn, _, _, _ = resume(c2, s2)
+	require.Nil(t, cconn)
+}
+
+func Test_VerifyClientCerts(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var tmpl = &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "alice"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	var config = &tls.Config{ClientCAs: x509.NewCertPool()}
+	config.ClientCAs.AddCert(cert)
+	require.NoError(t, verifyClientCerts(config, [][]byte{der}))
+
+	// CA rotated
+	config.ClientCAs = x509.NewCertPool()
+	require.Error(t, verifyClientCerts(config, [][]byte{der}))
+
+	// expired
+	tmpl.NotAfter = time.Now().Add(-time.Minute)
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	config.ClientCAs.AddCert(cert)
+	require.Error(t, verifyClientCerts(config, [][]byte{der}))
+}
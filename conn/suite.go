@@ -0,0 +1,90 @@
+package conn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"slices"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// CipherSuite data-plane aead cipher suite
+type CipherSuite uint8
+
+const (
+	_ CipherSuite = iota
+	AES128GCM
+	AES256GCM
+	ChaCha20Poly1305
+
+	// AuthOnly only authenticate packet by AES-128-GMAC, payload not be encrypted,
+	// for links that confidentiality is provided elsewhere.
+	AuthOnly
+)
+
+func (s CipherSuite) String() string {
+	switch s {
+	case AES128GCM:
+		return "AES-128-GCM"
+	case AES256GCM:
+		return "AES-256-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	case AuthOnly:
+		return "AES-128-GMAC"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+func (s CipherSuite) keySize() int {
+	switch s {
+	case AES128GCM, AuthOnly:
+		return 16
+	default:
+		return 32
+	}
+}
+
+func (s CipherSuite) aead(key key) (cipher.AEAD, error) {
+	switch s {
+	case AES128GCM, AES256GCM, AuthOnly:
+		block, err := aes.NewCipher(key[:s.keySize()])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		return aead, errors.WithStack(err)
+	case ChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key[:s.keySize()])
+		return aead, errors.WithStack(err)
+	default:
+		return nil, errors.Errorf("not support cipher suite %s", s)
+	}
+}
+
+var hasAESGCM = (cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ) ||
+	(cpu.ARM64.HasAES && cpu.ARM64.HasPMULL) ||
+	(cpu.S390X.HasAES && cpu.S390X.HasAESGCM)
+
+// defaultSuites prefer ChaCha20-Poly1305 if hardware not support AES-GCM,
+// AuthOnly never be negotiated by default.
+func defaultSuites() []CipherSuite {
+	if hasAESGCM {
+		return []CipherSuite{AES128GCM, AES256GCM, ChaCha20Poly1305}
+	}
+	return []CipherSuite{ChaCha20Poly1305, AES128GCM, AES256GCM}
+}
+
+// selectSuite select first suite of server's preference that client supported
+func selectSuite(server, client []CipherSuite) (CipherSuite, error) {
+	for _, e := range server {
+		if slices.Contains(client, e) {
+			return e, nil
+		}
+	}
+	return 0, errors.Errorf("not common cipher suite, server %v, client %v", server, client)
+}
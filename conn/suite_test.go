@@ -0,0 +1,43 @@
+package conn
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Crypto_Suites(t *testing.T) {
+	for _, suite := range []CipherSuite{AES128GCM, AES256GCM, ChaCha20Poly1305, AuthOnly} {
+		c, err := newCrypto(suite, key{1, 2, 3}, client, 0)
+		require.NoError(t, err)
+		s, err := newCrypto(suite, key{1, 2, 3}, server, 0)
+		require.NoError(t, err)
+
+		p := NewFamilyPeer().Reset(header.UDPProtocolNumber, netip.MustParseAddr("1.2.3.4"))
+		pkt := packet.Make(64, 0, 64).Append([]byte("hello")...)
+		require.NoError(t, p.Encode(pkt))
+		require.NoError(t, c.encrypt(pkt, p.Overhead(), Encrypt))
+		require.Equal(t, suite == AuthOnly, string(pkt.Bytes()[p.Overhead():p.Overhead()+5]) == "hello", suite)
+
+		// tamper
+		b := slices.Clone(pkt.Bytes())
+		b[p.Overhead()] ^= 1
+		require.Error(t, s.decrypt(packet.Make(0, 0, len(b)).Append(b...), p.Overhead()), suite)
+
+		require.NoError(t, s.decrypt(pkt, p.Overhead()), suite)
+		require.Equal(t, "hello", string(pkt.Bytes()[p.Overhead():]), suite)
+	}
+}
+
+func Test_SelectSuite(t *testing.T) {
+	suite, err := selectSuite([]CipherSuite{AES256GCM, ChaCha20Poly1305}, []CipherSuite{ChaCha20Poly1305, AES256GCM})
+	require.NoError(t, err)
+	require.Equal(t, AES256GCM, suite)
+
+	_, err = selectSuite(defaultSuites(), []CipherSuite{AuthOnly})
+	require.Error(t, err)
+}
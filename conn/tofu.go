@@ -0,0 +1,178 @@
+package conn
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SelfSigned generate self-signed certificate for server without PKI, hosts
+// are dns names or ip addresses, client should trust it by PinStore.
+func SelfSigned(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
+
+	var tmpl = &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fatun"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, e := range hosts {
+		if ip := net.ParseIP(e); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, e)
+		}
+	}
+	if len(hosts) > 0 {
+		tmpl.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// PinStore client trust-on-first-use store, pin server certificate fingerprint
+// by server address. every line of the file is a server address and it's hex
+// encoded sha256 certificate fingerprint:
+//
+//	1.2.3.4:19986 9f86d081...
+//
+// the first seen certificate is pinned, connect will be refused if it changed,
+// remove the line to trust new certificate.
+type PinStore struct {
+	path string
+
+	mu   sync.Mutex
+	pins map[string]string
+}
+
+// PinMismatchError server certificate not match pinned
+type PinMismatchError struct {
+	Addr   string
+	Pinned string
+	Got    string
+	Path   string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("server %s certificate changed, pinned %s, got %s, remove the pin from %s if trust it",
+		e.Addr, e.Pinned, e.Got, e.Path)
+}
+
+// NewPinStore the file will be created if not exist
+func NewPinStore(path string) (*PinStore, error) {
+	var s = &PinStore{path: path, pins: map[string]string{}}
+
+	fh, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer fh.Close()
+
+	var r = bufio.NewScanner(fh)
+	for i := 1; r.Scan(); i++ {
+		line := strings.TrimSpace(r.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("%s line %d: invalid pin", path, i)
+		}
+		s.pins[fields[0]] = strings.ToLower(fields[1])
+	}
+	return s, errors.WithStack(r.Err())
+}
+
+// TLS client tls config of the server address, set as Config.TLS
+func (s *PinStore) TLS(addr string) *tls.Config {
+	return &tls.Config{
+		// certificate verified by pin
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return s.verify(addr, rawCerts)
+		},
+	}
+}
+
+func (s *PinStore) verify(addr string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.Errorf("server %s not provide certificate", addr)
+	}
+	fp := fingerprint(rawCerts[0])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pinned, has := s.pins[addr]; has {
+		if pinned != fp {
+			return &PinMismatchError{Addr: addr, Pinned: pinned, Got: fp, Path: s.path}
+		}
+		return nil
+	}
+
+	s.pins[addr] = fp
+	return s.save()
+}
+
+// save write to temp file and rename, avoid corrupt the file
+func (s *PinStore) save() error {
+	var addrs = make([]string, 0, len(s.pins))
+	for e := range s.pins {
+		addrs = append(addrs, e)
+	}
+	slices.Sort(addrs)
+
+	var b strings.Builder
+	for _, e := range addrs {
+		fmt.Fprintf(&b, "%s %s\n", e, s.pins[e])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), s.path))
+}
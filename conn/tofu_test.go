@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PinStore(t *testing.T) {
+	const addr = "1.2.3.4:19986"
+	path := filepath.Join(t.TempDir(), "pins")
+
+	dial := func(store *PinStore, cert tls.Certificate) error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		return tls.Client(conn, store.TLS(addr)).Handshake()
+	}
+
+	cert1, err := SelfSigned("1.2.3.4", "example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com"}, cert1.Leaf.DNSNames)
+	cert2, err := SelfSigned()
+	require.NoError(t, err)
+
+	store, err := NewPinStore(path)
+	require.NoError(t, err)
+	require.NoError(t, dial(store, cert1)) // first use
+	require.NoError(t, dial(store, cert1))
+
+	// pin persisted
+	store, err = NewPinStore(path)
+	require.NoError(t, err)
+	require.NoError(t, dial(store, cert1))
+
+	err = dial(store, cert2)
+	var e *PinMismatchError
+	require.ErrorAs(t, err, &e)
+	require.Equal(t, addr, e.Addr)
+	require.Equal(t, fingerprint(cert1.Certificate[0]), e.Pinned)
+	require.Equal(t, fingerprint(cert2.Certificate[0]), e.Got)
+}
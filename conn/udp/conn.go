@@ -1,8 +1,12 @@
 package udp
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"net"
 	"net/netip"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -10,24 +14,143 @@ import (
 	"github.com/pkg/errors"
 )
 
-func Dial(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
-	return net.DialUDP("udp", laddr, raddr)
+// every client datagram prefixed connection id, server identify client by the
+// id instead of source address, so the session survive client NAT rebinding
+// and address changing:
+//
+//	{id 8B} {payload}
+type ID [8]byte
+
+func (id ID) String() string { return fmt.Sprintf("%016x", binary.BigEndian.Uint64(id[:])) }
+
+const idSize = len(ID{})
+
+// Conn client udp conn, datagram be prefixed connection id
+type Conn struct {
+	udp atomic.Pointer[net.UDPConn]
+	id  ID
+
+	wmu  sync.Mutex
+	buff []byte
+
+	closed atomic.Bool
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func Dial(laddr, raddr *net.UDPAddr) (*Conn, error) {
+	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var id ID
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return nil, errors.WithStack(err)
+	}
+	return newConn(conn, id), nil
+}
+
+func newConn(conn *net.UDPConn, id ID) *Conn {
+	var c = &Conn{id: id}
+	c.udp.Store(conn)
+	return c
+}
+
+func (c *Conn) ID() ID { return c.id }
+
+// Rebind replace the socket with a new one, that select source address by
+// current route and keep the local port, used after local network changed,
+// server migrate the session by connection id.
+func (c *Conn) Rebind() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	old := c.udp.Load()
+	laddr := &net.UDPAddr{Port: old.LocalAddr().(*net.UDPAddr).Port}
+	raddr := old.RemoteAddr().(*net.UDPAddr)
+
+	// release the port, the Read blocked on old socket will retry on new one
+	if err := old.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		if conn, err = net.DialUDP("udp", nil, raddr); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	c.udp.Store(conn)
+	if c.closed.Load() {
+		conn.Close()
+	}
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		conn := c.udp.Load()
+		n, err := conn.Read(b)
+		if err != nil && errors.Is(err, net.ErrClosed) && !c.closed.Load() {
+			c.wmu.Lock() // wait rebinding
+			rebinded := c.udp.Load() != conn
+			c.wmu.Unlock()
+			if rebinded {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.buff = append(append(c.buff[:0], c.id[:]...), b...)
+	n, err := c.udp.Load().Write(c.buff)
+	return max(n-idSize, 0), err
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.udp.Load().LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.udp.Load().RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.udp.Load().SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.udp.Load().SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.udp.Load().SetWriteDeadline(t) }
+
+func (c *Conn) Close() error {
+	c.closed.Store(true)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.udp.Load().Close()
 }
 
 type acceptConn struct {
-	l     *Listener
-	raddr netip.AddrPort
+	l  *Listener
+	id ID
+
+	mu       sync.RWMutex
+	raddr    netip.AddrPort
+	from     netip.AddrPort // source address of last read datagram
+	active   time.Time      // last authenticated datagram from raddr
+	migrated time.Time
 
 	closed atomic.Bool
-	buff   chan segment
+	buff   chan datagram
+}
+
+type datagram struct {
+	seg  segment
+	from netip.AddrPort
 }
 
 var _ net.Conn = (*acceptConn)(nil)
 
-func newAcceptConn(l *Listener, raddr netip.AddrPort) *acceptConn {
+func newAcceptConn(l *Listener, id ID, raddr netip.AddrPort) *acceptConn {
 	var c = &acceptConn{
-		l: l, raddr: raddr,
-		buff: make(chan *segmentData, 128), // todo: from config
+		l: l, id: id, raddr: raddr,
+		active: time.Now(),
+		buff:   make(chan datagram, 128), // todo: from config
 	}
 	return c
 }
@@ -36,26 +159,67 @@ func (c *acceptConn) Write(b []byte) (int, error) {
 	if c.closed.Load() {
 		return 0, errors.WithStack(net.ErrClosed)
 	}
-	return c.l.udp.WriteToUDPAddrPort(b, c.raddr)
+	c.mu.RLock()
+	raddr := c.raddr
+	c.mu.RUnlock()
+	return c.l.udp.WriteToUDPAddrPort(b, raddr)
 }
 
 func (c *acceptConn) Read(b []byte) (int, error) {
-	seg, ok := <-c.buff
+	d, ok := <-c.buff
 	if !ok {
 		return 0, errors.WithStack(net.ErrClosed)
 	}
-	defer func() { c.l.put(seg) }()
+	defer func() { c.l.put(d.seg) }()
+	c.mu.Lock()
+	c.from = d.from
+	c.mu.Unlock()
 
-	n := copy(b, *seg)
-	if n != len(*seg) {
-		return n, errorx.ShortBuff(len(*seg), n)
+	payload := (*d.seg)[idSize:]
+	n := copy(b, payload)
+	if n != len(payload) {
+		return n, errorx.ShortBuff(len(payload), n)
 	}
 	return n, nil
 }
 
+const (
+	// migrateQuiet migrate only after remote address silent, client roamed from
+	// old path, otherwise on-path attacker can move session by race datagram
+	migrateQuiet = time.Second
+	// migrateInterval min interval between migrations, if session be hijacked,
+	// it will be moved back by client's later datagrams
+	migrateInterval = time.Second * 5
+)
+
+// Migrate switch remote address to the source address of last read datagram,
+// should be called after the datagram be authenticated, return previous
+// remote address if migrated. the migration be deferred until remote address
+// silent for migrateQuiet, and rate limited by migrateInterval.
+func (c *acceptConn) Migrate() (old netip.AddrPort, migrated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if !c.from.IsValid() {
+		return c.raddr, false
+	} else if c.from == c.raddr {
+		c.active = now
+		return c.raddr, false
+	} else if now.Sub(c.active) < migrateQuiet || now.Sub(c.migrated) < migrateInterval {
+		return c.raddr, false
+	}
+	old, c.raddr = c.raddr, c.from
+	c.active, c.migrated = now, now
+	return old, true
+}
+
+func (c *acceptConn) ID() ID { return c.id }
+
 func (c *acceptConn) LocalAddr() net.Addr { return c.l.Addr() }
 func (c *acceptConn) RemoteAddr() net.Addr {
-	return &net.UDPAddr{IP: c.raddr.Addr().AsSlice(), Port: int(c.raddr.Port())}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return net.UDPAddrFromAddrPort(c.raddr)
 }
 func (c *acceptConn) SetDeadline(t time.Time) error      { panic("todo: ref gvisor gonet") }
 func (c *acceptConn) SetWriteDeadline(t time.Time) error { panic("todo: ref gvisor gonet") }
@@ -65,20 +229,20 @@ func (c *acceptConn) Close() error {
 	c.closed.Store(true)
 	close(c.buff)
 
-	c.l.del(c.raddr)
+	c.l.del(c.id)
 	for e := range c.buff {
-		c.l.put(e)
+		c.l.put(e.seg)
 	}
 	return nil
 }
 
-func (c *acceptConn) put(s segment) {
+func (c *acceptConn) put(d datagram) {
 	for !c.closed.Load() {
 		select {
-		case c.buff <- s: // probably painc write closed ch
+		case c.buff <- d: // probably painc write closed ch
 			return
 		default:
-			c.l.put(<-c.buff)
+			c.l.put((<-c.buff).seg)
 		}
 	}
 }
@@ -1,7 +1,130 @@
 package udp
 
-import "testing"
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
 
 func Test_acceptConn_buff(t *testing.T) {
 
 }
+
+func Test_Listener_Migrate(t *testing.T) {
+	var saddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8081}
+	l, err := Listen(saddr, 1500)
+	require.NoError(t, err)
+	defer l.Close()
+
+	c1, err := Dial(nil, saddr)
+	require.NoError(t, err)
+	defer c1.Close()
+	_, err = c1.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	a := conn.(*acceptConn)
+	require.Equal(t, c1.ID(), a.ID())
+
+	var b = make([]byte, 64)
+	n, err := a.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b[:n]))
+
+	// client address changed, same connection id
+	raw, err := net.DialUDP("udp", nil, saddr)
+	require.NoError(t, err)
+	c2 := newConn(raw, c1.ID())
+	defer c2.Close()
+	_, err = c2.Write([]byte("world"))
+	require.NoError(t, err)
+
+	n, err = a.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(b[:n]))
+	require.Equal(t, c1.LocalAddr().String(), a.RemoteAddr().String())
+
+	// old address still active, not migrate
+	_, migrated := a.Migrate()
+	require.False(t, migrated)
+
+	a.active = time.Now().Add(-migrateQuiet)
+	old, migrated := a.Migrate()
+	require.True(t, migrated)
+	require.Equal(t, netip.MustParseAddrPort(c1.LocalAddr().String()), old)
+	require.Equal(t, c2.LocalAddr().String(), a.RemoteAddr().String())
+
+	_, err = a.Write([]byte("reply"))
+	require.NoError(t, err)
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = c2.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "reply", string(b[:n]))
+
+	_, migrated = a.Migrate()
+	require.False(t, migrated)
+
+	// rate limited, c1 can't move session back immediately
+	_, err = c1.Write([]byte("again"))
+	require.NoError(t, err)
+	_, err = a.Read(b)
+	require.NoError(t, err)
+	a.active = time.Now().Add(-migrateQuiet)
+	_, migrated = a.Migrate()
+	require.False(t, migrated)
+	a.migrated = time.Now().Add(-migrateInterval)
+	_, migrated = a.Migrate()
+	require.True(t, migrated)
+	require.Equal(t, c1.LocalAddr().String(), a.RemoteAddr().String())
+}
+
+func Test_Conn_Rebind(t *testing.T) {
+	var saddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8082}
+	l, err := Listen(saddr, 1500)
+	require.NoError(t, err)
+	defer l.Close()
+
+	c, err := Dial(nil, saddr)
+	require.NoError(t, err)
+	defer c.Close()
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	_, err = c.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	var b = make([]byte, 64)
+	_, err = conn.Read(b)
+	require.NoError(t, err)
+
+	// blocked read survive rebind
+	var rets = make(chan string, 1)
+	go func() {
+		var b = make([]byte, 64)
+		n, err := c.Read(b)
+		require.NoError(t, err)
+		rets <- string(b[:n])
+	}()
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, c.Rebind())
+	require.Equal(t, port, c.LocalAddr().(*net.UDPAddr).Port)
+
+	_, err = c.Write([]byte("world"))
+	require.NoError(t, err)
+	n, err := conn.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(b[:n]))
+
+	_, err = conn.Write([]byte("reply"))
+	require.NoError(t, err)
+	select {
+	case s := <-rets:
+		require.Equal(t, "reply", s)
+	case <-time.After(time.Second):
+		t.Fatal("read blocked")
+	}
+}
@@ -19,7 +19,7 @@ type Listener struct {
 	pool *sync.Pool
 
 	connsMu sync.RWMutex
-	conns   map[netip.AddrPort]*acceptConn
+	conns   map[ID]*acceptConn
 
 	connCh chan net.Conn
 
@@ -30,7 +30,7 @@ var _ net.Listener = (*Listener)(nil)
 
 func Listen(addr *net.UDPAddr, maxRecvBuffSize int) (*Listener, error) {
 	var l = &Listener{
-		conns:  map[netip.AddrPort]*acceptConn{},
+		conns:  map[ID]*acceptConn{},
 		connCh: make(chan net.Conn, 32),
 	}
 
@@ -82,18 +82,25 @@ func (l *Listener) accpetService() (_ error) {
 			return l.close(err)
 		}
 		seg.data(n)
+		if n < idSize {
+			l.put(seg)
+			continue
+		}
 		// todo: 校验数据包
 
+		// identify by connection id, source address maybe changed, migrated
+		// by conn after authenticated
+		id := ID((*seg)[:idSize])
 		l.connsMu.RLock()
-		a, has := l.conns[addr]
+		a, has := l.conns[id]
 		l.connsMu.RUnlock()
 		if !has {
-			a = newAcceptConn(l, addr)
+			a = newAcceptConn(l, id, addr)
 			l.connsMu.Lock()
-			l.conns[addr] = a
+			l.conns[id] = a
 			l.connsMu.Unlock()
 		}
-		a.put(seg)
+		a.put(datagram{seg: seg, from: addr})
 		if !has && !l.closeErr.Closed() {
 			select {
 			case l.connCh <- a:
@@ -114,24 +121,24 @@ func (l *Listener) put(seg segment) {
 	}
 	l.pool.Put(seg)
 }
-func (l *Listener) del(raddr netip.AddrPort) {
+func (l *Listener) del(id ID) {
 	l.connsMu.RLock()
 	n := len(l.conns)
 	l.connsMu.RUnlock()
 
 	if n == 1 {
-		l._del(raddr)
+		l._del(id)
 	} else {
 		// tcp可以根据ISN进行判断, udp只能等待一段时间
-		time.AfterFunc(time.Second*5, func() { l._del(raddr) })
+		time.AfterFunc(time.Second*5, func() { l._del(id) })
 	}
 }
 
-func (l *Listener) _del(raddr netip.AddrPort) {
+func (l *Listener) _del(id ID) {
 	l.connsMu.Lock()
 	defer l.connsMu.Unlock()
 
-	delete(l.conns, raddr)
+	delete(l.conns, id)
 	if len(l.conns) == 0 && l.closeErr.Closed() {
 		l.udp.Close()
 	}
@@ -60,7 +60,7 @@ func Test_Listen_Base(t *testing.T) {
 	for _, e := range caddrs {
 		caddr := e
 		eg.Go(func() error {
-			conn, err := net.DialUDP("udp", caddr, saddr)
+			conn, err := udp.Dial(caddr, saddr)
 			require.NoError(t, err)
 
 			for i := 0; i < 3; i++ {
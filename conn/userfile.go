@@ -0,0 +1,184 @@
+package conn
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserFile user database file, every line is a user's name, credential kind,
+// credential hash, and optional "revoked" flag, line start with '#' is comment:
+//
+//	# name  kind      hash
+//	alice   password  $2a$10$...
+//	bob     token     9f86d081...
+//	carol   token     60303ae2...  revoked
+//
+// password hash is bcrypt, see HashPassword; token hash is hex encoded sha256,
+// see HashToken. the file will be reloaded if it's modified, established conn
+// of the user that revoked or credential changed will be closed.
+type UserFile struct {
+	path string
+
+	mu     sync.Mutex
+	mod    time.Time
+	users  map[string]user
+	tokens map[string]string // token hash : name
+}
+
+type user struct {
+	kind    credKind
+	hash    string
+	revoked bool
+}
+
+// version credential version, changed with credential hash
+func (u user) version() string {
+	b := sha256.Sum256([]byte(u.hash))
+	return hex.EncodeToString(b[:8])
+}
+
+var _ Authenticator = (*UserFile)(nil)
+
+func NewUserFile(path string) (*UserFile, error) {
+	var f = &UserFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *UserFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return errors.WithStack(err)
+	} else if info.ModTime().Equal(f.mod) {
+		return nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	users, err := parseUsers(b)
+	if err != nil {
+		return errors.WithMessage(err, f.path)
+	}
+	f.mod, f.users, f.tokens = info.ModTime(), users, map[string]string{}
+	for name, u := range users {
+		if u.kind == credToken {
+			f.tokens[u.hash] = name
+		}
+	}
+	return nil
+}
+
+// Authenticate the previous users be used if reload failed
+func (f *UserFile) Authenticate(cred Credential) (string, string, error) {
+	f.mu.Lock()
+	f.reload()
+	var (
+		name = cred.Username
+		u    user
+		has  bool
+	)
+	if cred.Token != "" {
+		name, has = f.tokens[HashToken(cred.Token)]
+		if has {
+			u = f.users[name]
+		}
+	} else {
+		u, has = f.users[name]
+	}
+	f.mu.Unlock()
+
+	if !has {
+		return "", "", errors.New("invalid credential")
+	}
+	switch u.kind {
+	case credToken:
+		if cred.Token == "" {
+			return "", "", errors.New("invalid credential")
+		}
+	case credPassword:
+		if cred.Token != "" || bcrypt.CompareHashAndPassword([]byte(u.hash), []byte(cred.Password)) != nil {
+			return "", "", errors.New("invalid credential")
+		}
+	}
+	if u.revoked {
+		return "", "", errors.New("credential revoked")
+	}
+	return name, u.version(), nil
+}
+
+// Check the user still exist, not be revoked and credential not be changed
+func (f *UserFile) Check(name, version string) error {
+	f.mu.Lock()
+	f.reload()
+	u, has := f.users[name]
+	f.mu.Unlock()
+
+	if !has {
+		return errors.New("invalid credential")
+	} else if u.revoked {
+		return errors.New("credential revoked")
+	} else if u.version() != version {
+		return errors.New("credential changed")
+	}
+	return nil
+}
+
+func parseUsers(b []byte) (map[string]user, error) {
+	var users = map[string]user{}
+	var s = bufio.NewScanner(strings.NewReader(string(b)))
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 4 || (len(fields) == 4 && fields[3] != "revoked") {
+			return nil, errors.Errorf("line %d: invalid user", i)
+		}
+
+		var u = user{hash: fields[2], revoked: len(fields) == 4}
+		switch fields[1] {
+		case "token":
+			u.kind, u.hash = credToken, strings.ToLower(u.hash)
+			if b, err := hex.DecodeString(u.hash); err != nil || len(b) != sha256.Size {
+				return nil, errors.Errorf("line %d: invalid token hash", i)
+			}
+		case "password":
+			u.kind = credPassword
+			if _, err := bcrypt.Cost([]byte(u.hash)); err != nil {
+				return nil, errors.Errorf("line %d: invalid password hash", i)
+			}
+		default:
+			return nil, errors.Errorf("line %d: unknown credential kind %s", i, fields[1])
+		}
+		if _, has := users[fields[0]]; has {
+			return nil, errors.Errorf("line %d: duplicate user %s", i, fields[0])
+		}
+		users[fields[0]] = u
+	}
+	return users, errors.WithStack(s.Err())
+}
+
+// HashPassword bcrypt hash password for UserFile
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), errors.WithStack(err)
+}
+
+// HashToken sha256 hash token for UserFile
+func HashToken(token string) string {
+	b := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,154 @@
+package fatun
+
+import (
+	"container/list"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSCache ip to domains cache, record by snoop dns response, an ip maybe
+// resolved by multiple domains, e.g. CDN or virtual host.
+type DNSCache struct {
+	// Grace keep record after ttl expired, because application
+	// usually cache dns longer than ttl
+	Grace time.Duration
+
+	// MaxSize max address count, the least recently put address be evicted
+	// if full, 0 means unlimited
+	MaxSize int
+
+	mu      sync.RWMutex
+	records map[netip.Addr]*list.Element // *record
+	lru     *list.List                   // recently put in front
+}
+
+type record struct {
+	addr    netip.Addr
+	domains map[string]time.Time // domain's expire time
+}
+
+func NewDNSCache() *DNSCache {
+	return &DNSCache{
+		Grace:   time.Minute,
+		MaxSize: 1 << 16,
+		records: map[netip.Addr]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Snoop parse dns response message, record the answered addresses
+func (d *DNSCache) Snoop(msg []byte) error {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return errors.WithStack(err)
+	} else if !hdr.Response || hdr.RCode != dnsmessage.RCodeSuccess {
+		return nil
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return errors.WithStack(err)
+	}
+	domain := normalize(q.Name.String())
+
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+
+		ttl := time.Duration(h.TTL) * time.Second
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			d.Put(netip.AddrFrom4(r.A), domain, ttl)
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			d.Put(netip.AddrFrom16(r.AAAA), domain, ttl)
+		default:
+			// e.g. CNAME, the final answered addresses be recorded as queried domain
+			if err := p.SkipAnswer(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
+
+func (d *DNSCache) Put(addr netip.Addr, domain string, ttl time.Duration) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	addr = addr.Unmap()
+	var r *record
+	if e, has := d.records[addr]; has {
+		d.lru.MoveToFront(e)
+		r = e.Value.(*record)
+		expire(r.domains, now)
+	} else {
+		if d.MaxSize > 0 && d.lru.Len() >= d.MaxSize {
+			old := d.lru.Remove(d.lru.Back()).(*record)
+			delete(d.records, old.addr)
+		}
+		r = &record{addr: addr, domains: map[string]time.Time{}}
+		d.records[addr] = d.lru.PushFront(r)
+	}
+	r.domains[normalize(domain)] = now.Add(ttl + d.Grace)
+}
+
+func expire(domains map[string]time.Time, now time.Time) {
+	for k, v := range domains {
+		if now.After(v) {
+			delete(domains, k)
+		}
+	}
+}
+
+// Lookup get the domains that resolved to addr
+func (d *DNSCache) Lookup(addr netip.Addr) (domains []string) {
+	now := time.Now()
+	d.mu.RLock()
+	if e, has := d.records[addr.Unmap()]; has {
+		for k, v := range e.Value.(*record).domains {
+			if !now.After(v) {
+				domains = append(domains, k)
+			}
+		}
+	}
+	d.mu.RUnlock()
+
+	slices.Sort(domains)
+	return domains
+}
+
+func normalize(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// MatchDomain match domain pattern, "*.example.com" match sub domain of example.com,
+// other pattern require equal.
+func MatchDomain(pattern, domain string) bool {
+	pattern, domain = normalize(pattern), normalize(domain)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(domain, suffix) && len(domain) > len(suffix)
+	}
+	return pattern == domain
+}
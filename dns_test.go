@@ -0,0 +1,82 @@
+package fatun_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/fatun"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func buildResponse(t *testing.T, name string, addrs ...netip.Addr) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	require.NoError(t, b.StartQuestions())
+	n := dnsmessage.MustNewName(name)
+	require.NoError(t, b.Question(dnsmessage.Question{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAnswers())
+	for _, a := range addrs {
+		h := dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassINET, TTL: 60}
+		if a.Is4() {
+			require.NoError(t, b.AResource(h, dnsmessage.AResource{A: a.As4()}))
+		} else {
+			require.NoError(t, b.AAAAResource(h, dnsmessage.AAAAResource{AAAA: a.As16()}))
+		}
+	}
+	msg, err := b.Finish()
+	require.NoError(t, err)
+	return msg
+}
+
+func Test_DNSCache(t *testing.T) {
+	var (
+		a4 = netip.MustParseAddr("93.184.216.34")
+		a6 = netip.MustParseAddr("2606:2800:220:1::1")
+	)
+
+	d := fatun.NewDNSCache()
+	require.NoError(t, d.Snoop(buildResponse(t, "WWW.Example.com.", a4, a6)))
+
+	require.Equal(t, []string{"www.example.com"}, d.Lookup(a4))
+	require.Equal(t, []string{"www.example.com"}, d.Lookup(a6))
+	require.Empty(t, d.Lookup(netip.MustParseAddr("1.1.1.1")))
+
+	// shared address keep all domains
+	require.NoError(t, d.Snoop(buildResponse(t, "cdn.example.org.", a4)))
+	require.Equal(t, []string{"cdn.example.org", "www.example.com"}, d.Lookup(a4))
+
+	// evict least recently put address
+	d.MaxSize = 2
+	d.Put(netip.MustParseAddr("1.1.1.1"), "one.one.one.one", time.Minute)
+	require.Empty(t, d.Lookup(a6))
+	require.NotEmpty(t, d.Lookup(a4))
+	require.Equal(t, []string{"one.one.one.one"}, d.Lookup(netip.MustParseAddr("1.1.1.1")))
+}
+
+func Test_MatchDomain(t *testing.T) {
+	require.True(t, fatun.MatchDomain("example.com", "Example.com."))
+	require.True(t, fatun.MatchDomain("*.example.com", "www.example.com"))
+	require.False(t, fatun.MatchDomain("*.example.com", "example.com"))
+	require.False(t, fatun.MatchDomain("example.com", "www.example.com"))
+}
+
+func Test_Rules_Domain(t *testing.T) {
+	var (
+		local  = netip.MustParseAddrPort("10.0.0.2:19986")
+		remote = netip.MustParseAddrPort("93.184.216.34:443")
+	)
+
+	r := fatun.NewRules(fatun.Rule{Action: fatun.Direct, Domain: []string{"*.example.com"}})
+	r.DNS = fatun.NewDNSCache()
+	s := fatun.Session{Src: local, Proto: header.TCPProtocolNumber, Dst: remote}
+	require.Equal(t, fatun.Tunnel, r.Match(s, fatun.Outbound))
+
+	r.DNS.Put(remote.Addr(), "www.example.com", time.Minute)
+	require.Equal(t, fatun.Direct, r.Match(s, fatun.Outbound))
+
+	// last resolved domain not override
+	r.DNS.Put(remote.Addr(), "www.example.org", time.Minute)
+	require.Equal(t, fatun.Direct, r.Match(s, fatun.Outbound))
+}
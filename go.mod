@@ -10,7 +10,8 @@ require (
 	github.com/lysShub/rawsock v0.0.0-20240528071759-a024e73796bb
 	github.com/mdlayher/arp v0.0.0-20220512170110-6706a2966875
 	github.com/stretchr/testify v1.9.0
-	golang.org/x/net v0.20.0
+	golang.org/x/crypto v0.22.0
+	golang.org/x/net v0.21.0
 	golang.org/x/sync v0.6.0
 	gvisor.dev/gvisor v0.0.0-20240521174809-5eedbf551134
 )
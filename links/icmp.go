@@ -0,0 +1,137 @@
+package links
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// IsICMPError icmp message is error message, that quote the origin packet
+func IsICMPError(proto tcpip.TransportProtocolNumber, icmp []byte) bool {
+	if len(icmp) < header.ICMPv4MinimumSize {
+		return false
+	}
+	switch proto {
+	case header.ICMPv4ProtocolNumber:
+		switch header.ICMPv4(icmp).Type() {
+		case header.ICMPv4DstUnreachable, header.ICMPv4TimeExceeded, header.ICMPv4ParamProblem:
+			return true
+		}
+	case header.ICMPv6ProtocolNumber:
+		switch header.ICMPv6(icmp).Type() {
+		case header.ICMPv6DstUnreachable, header.ICMPv6PacketTooBig, header.ICMPv6TimeExceeded, header.ICMPv6ParamProblem:
+			return true
+		}
+	}
+	return false
+}
+
+// Quoted get the Downlink of icmp error message's quoted packet, the quoted packet
+// is sent by proxy-server, so Downlink.Local is the quoted packet's source address.
+func Quoted(icmp []byte) (Downlink, error) {
+	inner, proto, hdrLen, err := quoted(icmp)
+	if err != nil {
+		return Downlink{}, err
+	}
+	t := inner[hdrLen:]
+
+	var src, dst netip.Addr
+	if header.IPVersion(inner) == 4 {
+		src = netip.AddrFrom4(header.IPv4(inner).SourceAddress().As4())
+		dst = netip.AddrFrom4(header.IPv4(inner).DestinationAddress().As4())
+	} else {
+		src = netip.AddrFrom16(header.IPv6(inner).SourceAddress().As16())
+		dst = netip.AddrFrom16(header.IPv6(inner).DestinationAddress().As16())
+	}
+
+	switch proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		return Downlink{
+			Server: netip.AddrPortFrom(dst, binary.BigEndian.Uint16(t[2:])),
+			Proto:  proto,
+			Local:  netip.AddrPortFrom(src, binary.BigEndian.Uint16(t[0:])),
+		}, nil
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		if t[0] != byte(header.ICMPv4Echo) && t[0] != byte(header.ICMPv6EchoRequest) {
+			return Downlink{}, errors.Errorf("not support quoted icmp type %d", t[0])
+		}
+		return Downlink{
+			Server: netip.AddrPortFrom(dst, 0),
+			Proto:  proto,
+			Local:  netip.AddrPortFrom(src, binary.BigEndian.Uint16(t[4:])),
+		}, nil
+	default:
+		return Downlink{}, errors.Errorf("not support quoted protocol %d", proto)
+	}
+}
+
+// SetQuotedPort set icmp error message's quoted packet source port(or echo identifier),
+// not update any checksum.
+func SetQuotedPort(icmp []byte, port uint16) error {
+	inner, proto, hdrLen, err := quoted(icmp)
+	if err != nil {
+		return err
+	}
+	t := inner[hdrLen:]
+
+	switch proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		binary.BigEndian.PutUint16(t[0:], port)
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		binary.BigEndian.PutUint16(t[4:], port)
+	default:
+		return errors.Errorf("not support quoted protocol %d", proto)
+	}
+	return nil
+}
+
+// SetQuotedSrc set icmp error message's quoted packet source address, and update
+// quoted ipv4 header checksum.
+func SetQuotedSrc(icmp []byte, src netip.Addr) error {
+	inner, _, _, err := quoted(icmp)
+	if err != nil {
+		return err
+	}
+
+	if header.IPVersion(inner) == 4 {
+		if !src.Is4() {
+			return errors.Errorf("invalid quoted source address %s", src.String())
+		}
+		hdr := header.IPv4(inner)
+		hdr.SetSourceAddress(tcpip.AddrFrom4(src.As4()))
+		hdr.SetChecksum(0)
+		hdr.SetChecksum(^hdr.CalculateChecksum())
+	} else {
+		if !src.Is6() {
+			return errors.Errorf("invalid quoted source address %s", src.String())
+		}
+		header.IPv6(inner).SetSourceAddress(tcpip.AddrFrom16(src.As16()))
+	}
+	return nil
+}
+
+// quoted get quoted packet, that include ip header and at least 8 bytes transport header
+func quoted(icmp []byte) (inner []byte, proto tcpip.TransportProtocolNumber, hdrLen int, err error) {
+	if len(icmp) < header.ICMPv4MinimumSize+header.IPv4MinimumSize+8 {
+		return nil, 0, 0, errors.New("invalid icmp error message")
+	}
+	inner = icmp[header.ICMPv4MinimumSize:]
+
+	switch ver := header.IPVersion(inner); ver {
+	case 4:
+		hdrLen = int(header.IPv4(inner).HeaderLength())
+		proto = header.IPv4(inner).TransportProtocol()
+	case 6:
+		hdrLen = header.IPv6FixedHeaderSize
+		proto = header.IPv6(inner).TransportProtocol()
+	default:
+		return nil, 0, 0, errors.Errorf("invalid quoted ip version %d", ver)
+	}
+	if len(inner) < hdrLen+8 {
+		return nil, 0, 0, errors.New("invalid icmp error message")
+	}
+	return inner, proto, hdrLen, nil
+}
@@ -0,0 +1,51 @@
+package links
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Quoted(t *testing.T) {
+	var (
+		local  = netip.MustParseAddrPort("10.0.0.2:19986")
+		server = netip.MustParseAddrPort("8.8.8.8:53")
+	)
+
+	// udp packet sent by proxy-server
+	var inner = make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize)
+	header.IPv4(inner).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(inner)),
+		TTL:         1,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(local.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(server.Addr().As4()),
+	})
+	header.IPv4(inner).SetChecksum(^header.IPv4(inner).CalculateChecksum())
+	header.UDP(inner[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+		SrcPort: local.Port(), DstPort: server.Port(), Length: header.UDPMinimumSize,
+	})
+
+	icmp := header.ICMPv4(append(make([]byte, header.ICMPv4MinimumSize), inner...))
+	icmp.SetType(header.ICMPv4TimeExceeded)
+	require.True(t, IsICMPError(header.ICMPv4ProtocolNumber, icmp))
+
+	link, err := Quoted(icmp)
+	require.NoError(t, err)
+	require.Equal(t, Downlink{Server: server, Proto: header.UDPProtocolNumber, Local: local}, link)
+
+	client := netip.MustParseAddrPort("192.168.1.5:5353")
+	require.NoError(t, SetQuotedPort(icmp, client.Port()))
+	require.NoError(t, SetQuotedSrc(icmp, client.Addr()))
+
+	link, err = Quoted(icmp)
+	require.NoError(t, err)
+	require.Equal(t, Downlink{Server: server, Proto: header.UDPProtocolNumber, Local: client}, link)
+	require.True(t, header.IPv4(icmp[header.ICMPv4MinimumSize:]).IsChecksumValid())
+
+	icmp.SetType(header.ICMPv4EchoReply)
+	require.False(t, IsICMPError(header.ICMPv4ProtocolNumber, icmp))
+}
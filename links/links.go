@@ -22,6 +22,12 @@ type LinksManager interface {
 	Add(link Uplink, conn conn.Conn) (localPort uint16, err error)
 	// Cleanup clean timeout ttl link
 	Cleanup() []Link
+	// Migrate move the conn's links from previous client address to the new
+	// address, after client address changed, return migrated links count
+	Migrate(conn conn.Conn, from, to netip.Addr) int
+	// Resume hand over links of previous conn to the reconnected conn, return
+	// resumed links count
+	Resume(from, to conn.Conn) int
 
 	Close() error
 }
@@ -49,38 +55,74 @@ func (d Downlink) String() string {
 type Link struct {
 	Uplink
 	Local netip.AddrPort
+
+	Identity conn.Identity // client identity
 }
 
 func (l Link) String() string {
-	return fmt.Sprintf("{Proto:%s, Process:%s, Local:%s, Server:%s}",
+	return fmt.Sprintf("{Proto:%s, Process:%s, Local:%s, Server:%s, Identity:%s}",
 		protostr(l.Proto),
 		l.Process.String(),
 		l.Local.String(),
 		l.Server.String(),
+		l.Identity.String(),
 	)
 }
 
 func StripIP(ip *packet.Packet) (Downlink, error) {
-	if header.IPVersion(ip.Bytes()) != 4 {
-		return Downlink{}, errors.New("only support ipv4")
+	var (
+		proto    tcpip.TransportProtocolNumber
+		src, dst netip.Addr
+		payload  []byte
+		hdrLen   int
+	)
+	switch ver := header.IPVersion(ip.Bytes()); ver {
+	case 4:
+		hdr := header.IPv4(ip.Bytes())
+		proto, payload, hdrLen = hdr.TransportProtocol(), hdr.Payload(), int(hdr.HeaderLength())
+		src = netip.AddrFrom4(hdr.SourceAddress().As4())
+		dst = netip.AddrFrom4(hdr.DestinationAddress().As4())
+	case 6:
+		hdr := header.IPv6(ip.Bytes())
+		proto, payload, hdrLen = hdr.TransportProtocol(), hdr.Payload(), header.IPv6FixedHeaderSize
+		src = netip.AddrFrom16(hdr.SourceAddress().As16())
+		dst = netip.AddrFrom16(hdr.DestinationAddress().As16())
+	default:
+		return Downlink{}, errors.Errorf("invalid ip version %d", ver)
 	}
 
-	hdr := header.IPv4(ip.Bytes())
-	switch hdr.TransportProtocol() {
+	var srcPort, dstPort uint16
+	switch proto {
 	case tcp.ProtocolNumber, udp.ProtocolNumber:
+		t := header.UDP(payload)
+		srcPort, dstPort = t.SourcePort(), t.DestinationPort()
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		// echo reply identifier as local port
+		if len(payload) < header.ICMPv4MinimumSize {
+			return Downlink{}, errors.New("invalid icmp message")
+		} else if (proto == header.ICMPv4ProtocolNumber && header.ICMPv4(payload).Type() != header.ICMPv4EchoReply) ||
+			(proto == header.ICMPv6ProtocolNumber && header.ICMPv6(payload).Type() != header.ICMPv6EchoReply) {
+			return Downlink{}, errors.Errorf("not support icmp type %d", payload[0])
+		}
+		dstPort = header.ICMPv4(payload).Ident()
 	default:
-		return Downlink{}, errors.Errorf("not support protocol %d", hdr.Protocol())
+		if !IsGeneric(proto) {
+			return Downlink{}, errors.Errorf("not support protocol %d", proto)
+		}
+		// generic ip protocol without port
 	}
-	t := header.UDP(hdr.Payload())
 
-	ip.SetHead(ip.Head() + int(hdr.HeaderLength()))
+	ip.SetHead(ip.Head() + hdrLen)
 	return Downlink{
-		Server: netip.AddrPortFrom(netip.AddrFrom4(hdr.SourceAddress().As4()), t.SourcePort()),
-		Proto:  hdr.TransportProtocol(),
-		Local:  netip.AddrPortFrom(netip.AddrFrom4(hdr.DestinationAddress().As4()), t.DestinationPort()),
+		Server: netip.AddrPortFrom(src, srcPort),
+		Proto:  proto,
+		Local:  netip.AddrPortFrom(dst, dstPort),
 	}, nil
 }
 
+// IsGeneric see conn.IsGeneric
+func IsGeneric(proto tcpip.TransportProtocolNumber) bool { return conn.IsGeneric(proto) }
+
 type Heap[T any] struct {
 	vals       []T
 	sart, size int
@@ -152,6 +194,12 @@ func protostr(num tcpip.TransportProtocolNumber) string {
 		return "icmp"
 	case header.ICMPv6ProtocolNumber:
 		return "icmp6"
+	case 47:
+		return "gre"
+	case 50:
+		return "esp"
+	case 132:
+		return "sctp"
 	default:
 		return fmt.Sprintf("unknown(%d)", int(num))
 	}
@@ -83,8 +83,10 @@ func (t *linkManager) Cleanup() []links.Link {
 	for i := 0; i < t.ttl.Size(); i++ {
 		i := t.ttl.Pop()
 		if i.valid() && time.Since(i.start) > t.duration {
-			p := t.uplinkMap[i.up]
-			if p.Idle() {
+			p, has := t.uplinkMap[i.up]
+			if !has {
+				continue // migrated
+			} else if p.Idle() {
 				ups = append(ups, i.up)
 				ls = append(ls, links.Link{Uplink: i.up, Local: netip.AddrPortFrom(t.addr, p.Port())})
 
@@ -105,6 +107,9 @@ func (t *linkManager) Cleanup() []links.Link {
 	t.donwlinkMu.Lock()
 	for i, e := range ups {
 		s := links.Downlink{Server: e.Server, Proto: e.Proto, Local: netip.AddrPortFrom(t.addr, ls[i].Local.Port())}
+		if key, has := t.downlinkMap[s]; has {
+			ls[i].Identity = key.conn.Identity()
+		}
 		delete(t.downlinkMap, s)
 	}
 	t.donwlinkMu.Unlock()
@@ -165,6 +170,47 @@ func (t *linkManager) Downlink(s links.Downlink) (conn conn.Conn, clientPort uin
 	return key.conn, key.clientPort, true
 }
 
+func (t *linkManager) Migrate(conn conn.Conn, from, to netip.Addr) (n int) {
+	t.uplinkMu.Lock()
+	defer t.uplinkMu.Unlock()
+	t.donwlinkMu.RLock()
+	defer t.donwlinkMu.RUnlock()
+
+	for up, p := range t.uplinkMap {
+		if up.Process.Addr() != from {
+			continue
+		}
+		down := links.Downlink{Server: up.Server, Proto: up.Proto, Local: netip.AddrPortFrom(t.addr, p.Port())}
+		if key, has := t.downlinkMap[down]; !has || key.conn != conn {
+			continue
+		}
+
+		newUp := up
+		newUp.Process = netip.AddrPortFrom(to, up.Process.Port())
+		if _, has := t.uplinkMap[newUp]; has {
+			continue
+		}
+		delete(t.uplinkMap, up)
+		t.uplinkMap[newUp] = p
+		t.ttl.Put(ttlkey{up: newUp, start: time.Now()})
+		n++
+	}
+	return n
+}
+
+func (t *linkManager) Resume(from, to conn.Conn) (n int) {
+	t.donwlinkMu.Lock()
+	defer t.donwlinkMu.Unlock()
+
+	for down, key := range t.downlinkMap {
+		if key.conn == from {
+			t.downlinkMap[down] = downkey{conn: to, clientPort: key.clientPort}
+			n++
+		}
+	}
+	return n
+}
+
 func (t *linkManager) Close() error {
 	return t.ap.Close()
 }
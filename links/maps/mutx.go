@@ -46,6 +46,20 @@ func (m *mutxLinkManager) Cleanup() (ls []links.Link) {
 	return ls
 }
 
+func (m *mutxLinkManager) Migrate(conn conn.Conn, from, to netip.Addr) (n int) {
+	for _, e := range m.mgrs {
+		n += e.Migrate(conn, from, to)
+	}
+	return n
+}
+
+func (m *mutxLinkManager) Resume(from, to conn.Conn) (n int) {
+	for _, e := range m.mgrs {
+		n += e.Resume(from, to)
+	}
+	return n
+}
+
 func (m *mutxLinkManager) Close() error {
 	return m.ap.Close()
 }
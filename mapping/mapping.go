@@ -0,0 +1,6 @@
+/*
+	mapping of local-addr <==> process, linux implement of
+	github.com/lysShub/netkit/mapping/process.Mapping
+*/
+
+package mapping
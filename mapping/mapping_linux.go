@@ -0,0 +1,261 @@
+//go:build linux
+// +build linux
+
+package mapping
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/lysShub/fatun/netlink"
+	"github.com/lysShub/netkit/mapping/process"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const sockDiagByFamily = 20 // SOCK_DIAG_BY_FAMILY
+
+// inet_diag_req_v2
+type inetDiagReq struct {
+	family, protocol, ext, pad uint8
+	states                     uint32
+	id                         inetDiagSockid
+}
+
+// inet_diag_sockid
+type inetDiagSockid struct {
+	sport, dport [2]byte
+	src, dst     [16]byte
+	ifi          uint32
+	cookie       [2]uint32
+}
+
+// inet_diag_msg
+type inetDiagMsg struct {
+	family, state, timer, retrans uint8
+	id                            inetDiagSockid
+	expires, rqueue, wqueue, uid  uint32
+	inode                         uint32
+}
+
+// Mapping find local socket owner process by sock_diag, and map socket
+// inode to pid by /proc/[pid]/fd
+type Mapping struct {
+	mu     sync.RWMutex
+	inodes map[uint32]uint32    // inode:pid
+	missed map[uint32]time.Time // inode:scan-time, avoid rescan for not record inode
+}
+
+var _ process.Mapping = (*Mapping)(nil)
+
+func New() (*Mapping, error) {
+	var m = &Mapping{inodes: map[uint32]uint32{}, missed: map[uint32]time.Time{}}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m, m.scan()
+}
+
+func (m *Mapping) Close() error { return nil }
+
+func (m *Mapping) Name(laddr netip.AddrPort, proto uint8) (string, error) {
+	pid, err := m.Pid(laddr, proto)
+	if err != nil {
+		return "", err
+	}
+	return Comm(pid)
+}
+
+func (m *Mapping) Pid(laddr netip.AddrPort, proto uint8) (uint32, error) {
+	inode, err := Inode(laddr, proto)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	pid, has := m.inodes[inode]
+	m.mu.RUnlock()
+	if has {
+		return pid, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, has := m.missed[inode]; has && time.Since(t) < minScanPeriod {
+		return 0, errors.WithStack(process.ErrNotRecord{})
+	}
+	if err := m.scan(); err != nil {
+		return 0, err
+	}
+	pid, has = m.inodes[inode]
+	if !has {
+		m.missed[inode] = time.Now()
+		return 0, errors.WithStack(process.ErrNotRecord{})
+	}
+	return pid, nil
+}
+
+func (m *Mapping) Pids() (pids []uint32) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pid := range m.inodes {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+	return slices.Compact(pids)
+}
+
+func (m *Mapping) Names() (names []string) {
+	for _, pid := range m.Pids() {
+		if name, err := Comm(pid); err == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+const minScanPeriod = time.Millisecond * 100
+
+// scan all process socket fd, require hold lock
+func (m *Mapping) scan() error {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	clear(m.inodes)
+	for k, t := range m.missed {
+		if time.Since(t) > minScanPeriod {
+			delete(m.missed, k)
+		}
+	}
+	for _, p := range procs {
+		pid, err := strconv.ParseUint(p.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue // process exited or permission denied
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(link[len("socket:["):len(link)-1], 10, 32)
+			if err == nil {
+				m.inodes[uint32(inode)] = uint32(pid)
+			}
+		}
+	}
+	return nil
+}
+
+// Inode get the socket inode that bind to laddr
+func Inode(laddr netip.AddrPort, proto uint8) (uint32, error) {
+	switch proto {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP:
+	default:
+		return 0, errors.Errorf("not support protocol %d", proto)
+	}
+
+	families := []uint8{unix.AF_INET6}
+	if laddr.Addr().Unmap().Is4() {
+		// ipv4 also maybe from dual-stack socket
+		families = []uint8{unix.AF_INET, unix.AF_INET6}
+	}
+	for _, family := range families {
+		inode, err := inode(family, laddr, proto)
+		if err == nil || !errors.Is(err, process.ErrNotRecord{}) {
+			return inode, err
+		}
+	}
+	return 0, errors.WithStack(process.ErrNotRecord{})
+}
+
+func inode(family uint8, laddr netip.AddrPort, proto uint8) (uint32, error) {
+	var req = inetDiagReq{
+		family:   family,
+		protocol: proto,
+		states:   0xffffffff,
+	}
+	msgs, err := netlink.Request(
+		unix.NETLINK_INET_DIAG, sockDiagByFamily, unix.NLM_F_DUMP,
+		unsafe.Slice((*byte)(unsafe.Pointer(&req)), unsafe.Sizeof(req)),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var wildcard uint32
+	for _, e := range msgs {
+		if len(e.Data) < int(unsafe.Sizeof(inetDiagMsg{})) {
+			continue
+		}
+		msg := (*inetDiagMsg)(unsafe.Pointer(unsafe.SliceData(e.Data)))
+		if binary.BigEndian.Uint16(msg.id.sport[:]) != laddr.Port() {
+			continue
+		}
+
+		var src netip.Addr
+		if msg.family == unix.AF_INET {
+			src = netip.AddrFrom4([4]byte(msg.id.src[:4]))
+		} else {
+			src = netip.AddrFrom16(msg.id.src).Unmap()
+		}
+		if src == laddr.Addr().Unmap() {
+			return msg.inode, nil
+		} else if src.IsUnspecified() && wildcard == 0 {
+			wildcard = msg.inode
+		}
+	}
+	if wildcard != 0 {
+		return wildcard, nil
+	}
+	return 0, errors.WithStack(process.ErrNotRecord{})
+}
+
+// Comm get process name
+func Comm(pid uint32) (string, error) {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(int(pid)), "comm"))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Exe get process executable path
+func Exe(pid uint32) (string, error) {
+	exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(int(pid)), "exe"))
+	return exe, errors.WithStack(err)
+}
+
+// Ppid get parent process id
+func Ppid(pid uint32) (uint32, error) {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(int(pid)), "stat"))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	// pid (comm) state ppid ..., comm maybe contain space or ')'
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0, errors.Errorf("invalid stat %s", string(b))
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 2 {
+		return 0, errors.Errorf("invalid stat %s", string(b))
+	}
+	ppid, err := strconv.ParseUint(fields[1], 10, 32)
+	return uint32(ppid), errors.WithStack(err)
+}
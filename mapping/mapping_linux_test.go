@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package mapping_test
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/lysShub/fatun/mapping"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_Mapping_Pid(t *testing.T) {
+	m, err := mapping.New()
+	require.NoError(t, err)
+	defer m.Close()
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+		laddr := netip.MustParseAddrPort(conn.LocalAddr().String())
+
+		pid, err := m.Pid(laddr, unix.IPPROTO_UDP)
+		require.NoError(t, err)
+		require.Equal(t, uint32(os.Getpid()), pid)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer l.Close()
+		laddr := netip.MustParseAddrPort(l.Addr().String())
+
+		pid, err := m.Pid(laddr, unix.IPPROTO_TCP)
+		require.NoError(t, err)
+		require.Equal(t, uint32(os.Getpid()), pid)
+	})
+}
+
+func Test_Ppid(t *testing.T) {
+	ppid, err := mapping.Ppid(uint32(os.Getpid()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(os.Getppid()), ppid)
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"net/netip"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// AddAddr add address to interface, like `ip addr add`, ipv6 address
+// skip duplicate address detection, that can be used immediately.
+func AddAddr(ifindex uint32, addr netip.Prefix) error {
+	return addrRequest(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, ifindex, addr)
+}
+
+func DelAddr(ifindex uint32, addr netip.Prefix) error {
+	return addrRequest(unix.RTM_DELADDR, 0, ifindex, addr)
+}
+
+func addrRequest(typ, flags uint16, ifindex uint32, addr netip.Prefix) error {
+	if !addr.IsValid() {
+		return errors.Errorf("invalid address %s", addr.String())
+	}
+	msg := unix.IfAddrmsg{
+		Family:    family(addr.Addr()),
+		Prefixlen: uint8(addr.Bits()),
+		Scope:     unix.RT_SCOPE_UNIVERSE,
+		Index:     ifindex,
+	}
+	if addr.Addr().Is6() {
+		msg.Flags = unix.IFA_F_NODAD
+	}
+
+	body := unsafe.Slice((*byte)(unsafe.Pointer(&msg)), unix.SizeofIfAddrmsg)
+	_, err := Request(unix.NETLINK_ROUTE, typ, flags, body,
+		BytesAttr(unix.IFA_LOCAL, addr.Addr().AsSlice()),
+		BytesAttr(unix.IFA_ADDRESS, addr.Addr().AsSlice()),
+	)
+	return errors.WithMessage(err, addr.String())
+}
@@ -0,0 +1,6 @@
+/*
+	minimal rtnetlink helper, only implement the messages fatun need
+	(route, rule, nfqueue, change notify), not a general netlink library.
+*/
+
+package netlink
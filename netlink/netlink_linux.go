@@ -0,0 +1,173 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+type Attr struct {
+	Type  uint16
+	Value []byte
+}
+
+func U8Attr(typ uint16, v uint8) Attr { return Attr{Type: typ, Value: []byte{v}} }
+func U32Attr(typ uint16, v uint32) Attr {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, v)
+	return Attr{Type: typ, Value: b}
+}
+func BytesAttr(typ uint16, v []byte) Attr { return Attr{Type: typ, Value: v} }
+
+func (a Attr) len() int { return unix.SizeofRtAttr + len(a.Value) }
+
+func (a Attr) encode(b []byte) int {
+	binary.NativeEndian.PutUint16(b[0:], uint16(a.len()))
+	binary.NativeEndian.PutUint16(b[2:], a.Type)
+	copy(b[unix.SizeofRtAttr:], a.Value)
+	return rtaAlign(a.len())
+}
+
+// NLA_F_NESTED | NLA_F_NET_BYTEORDER
+const nlaTypeMask = ^uint16(0x8000 | 0x4000)
+
+func rtaAlign(n int) int { return (n + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1) }
+
+var seq atomic.Uint32
+
+// Conn netlink socket
+type Conn struct {
+	fd   int
+	buff []byte
+}
+
+func Dial(proto int, groups uint32) (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	return &Conn{fd: fd, buff: make([]byte, 0xffff+unix.Getpagesize())}, nil
+}
+
+// Send send a netlink message, return the message sequence
+func (c *Conn) Send(typ, flags uint16, body []byte, attrs ...Attr) (uint32, error) {
+	n := unix.SizeofNlMsghdr + rtaAlign(len(body))
+	for _, a := range attrs {
+		n += rtaAlign(a.len())
+	}
+	var (
+		msg = make([]byte, n)
+		hdr = (*unix.NlMsghdr)(unsafe.Pointer(&msg[0]))
+		s   = seq.Add(1)
+	)
+	hdr.Len = uint32(n)
+	hdr.Type = typ
+	hdr.Flags = flags | unix.NLM_F_REQUEST
+	hdr.Seq = s
+	i := unix.SizeofNlMsghdr + copy(msg[unix.SizeofNlMsghdr:], body)
+	i = rtaAlign(i)
+	for _, a := range attrs {
+		i += a.encode(msg[i:])
+	}
+
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return s, nil
+}
+
+// Recv receive netlink messages, the returned messages be valid until next Recv
+func (c *Conn) Recv() ([]syscall.NetlinkMessage, error) {
+	n, _, err := unix.Recvfrom(c.fd, c.buff, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(c.buff[:n])
+	return msgs, errors.WithStack(err)
+}
+
+func (c *Conn) Close() error { return errors.WithStack(unix.Close(c.fd)) }
+
+// Request send a netlink request and wait ack, return the reply messages
+func Request(proto int, typ, flags uint16, body []byte, attrs ...Attr) ([]syscall.NetlinkMessage, error) {
+	c, err := Dial(proto, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	s, err := c.Send(typ, flags|unix.NLM_F_ACK, body, attrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []syscall.NetlinkMessage
+	for {
+		ms, err := c.Recv()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			if m.Header.Seq != s {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_ERROR:
+				if err := Errno(m); err != nil {
+					return nil, err
+				}
+				return msgs, nil // ack
+			case unix.NLMSG_DONE:
+				return msgs, nil
+			default:
+				m.Data = append([]byte{}, m.Data...)
+				msgs = append(msgs, m)
+			}
+		}
+	}
+}
+
+// Errno get error of NLMSG_ERROR message, nil means ack
+func Errno(m syscall.NetlinkMessage) error {
+	if len(m.Data) < unix.SizeofNlMsgerr {
+		return errors.New("invalid netlink error message")
+	}
+	e := (*unix.NlMsgerr)(unsafe.Pointer(unsafe.SliceData(m.Data)))
+	if e.Error != 0 {
+		return errors.WithStack(unix.Errno(-e.Error))
+	}
+	return nil
+}
+
+// ParseAttrs parse netlink attributes
+func ParseAttrs(b []byte) ([]Attr, error) {
+	var attrs []Attr
+	for len(b) >= unix.SizeofRtAttr {
+		n := int(binary.NativeEndian.Uint16(b[0:]))
+		typ := binary.NativeEndian.Uint16(b[2:])
+		if n < unix.SizeofRtAttr || n > len(b) {
+			return nil, errors.Errorf("invalid netlink attribute length %d", n)
+		}
+		attrs = append(attrs, Attr{Type: typ & nlaTypeMask, Value: b[unix.SizeofRtAttr:n]})
+		b = b[min(rtaAlign(n), len(b)):]
+	}
+	return attrs, nil
+}
+
+func family(addr interface{ Is4() bool }) uint8 {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
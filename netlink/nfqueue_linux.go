@@ -0,0 +1,165 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// linux/netfilter/nfnetlink_queue.h
+const (
+	nfqnlMsgPacket  = 0
+	nfqnlMsgVerdict = 1
+	nfqnlMsgConfig  = 2
+
+	nfqaCfgCmd    = 1
+	nfqaCfgParams = 2
+	nfqaCfgMask   = 4
+	nfqaCfgFlags  = 5
+
+	nfqaPacketHdr  = 1
+	nfqaVerdictHdr = 2
+	nfqaPayload    = 10
+
+	nfqnlCfgCmdBind  = 1
+	nfqnlCopyPacket  = 2
+	nfqaCfgFFailOpen = 1
+)
+
+// netfilter verdict
+const (
+	NfDrop   uint32 = 0
+	NfAccept uint32 = 1
+)
+
+// Queue netfilter queue, read packet that be queued by NFQUEUE target,
+// and every packet require a verdict.
+type Queue struct {
+	conn    *Conn
+	num     uint16
+	pending []syscall.NetlinkMessage
+}
+
+func OpenQueue(num uint16, family uint8) (*Queue, error) {
+	c, err := Dial(unix.NETLINK_NETFILTER, 0)
+	if err != nil {
+		return nil, err
+	}
+	var q = &Queue{conn: c, num: num}
+
+	if err := q.config(cfgCmd(nfqnlCfgCmdBind, family)); err != nil {
+		return nil, q.close(err)
+	}
+
+	params := make([]byte, 5) // nfqnl_msg_config_params, packed
+	binary.BigEndian.PutUint32(params, 0xffff)
+	params[4] = nfqnlCopyPacket
+	flags := make([]byte, 4)
+	binary.BigEndian.PutUint32(flags, nfqaCfgFFailOpen)
+	if err := q.config(
+		BytesAttr(nfqaCfgParams, params),
+		BytesAttr(nfqaCfgFlags, flags),
+		BytesAttr(nfqaCfgMask, flags),
+	); err != nil {
+		return nil, q.close(err)
+	}
+	return q, nil
+}
+
+func cfgCmd(cmd uint8, family uint8) Attr {
+	// nfqnl_msg_config_cmd
+	return BytesAttr(nfqaCfgCmd, []byte{cmd, 0, 0, family})
+}
+
+func (q *Queue) nfgenmsg() []byte {
+	b := make([]byte, 4) // nfgenmsg
+	b[0] = unix.AF_UNSPEC
+	b[1] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(b[2:], q.num)
+	return b
+}
+
+func (q *Queue) config(attrs ...Attr) error {
+	s, err := q.conn.Send(unix.NFNL_SUBSYS_QUEUE<<8|nfqnlMsgConfig, unix.NLM_F_ACK, q.nfgenmsg(), attrs...)
+	if err != nil {
+		return err
+	}
+	for {
+		msgs, err := q.conn.Recv()
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq == s && m.Header.Type == unix.NLMSG_ERROR {
+				return Errno(m)
+			}
+		}
+	}
+}
+
+// Read read a queued packet, the packet is pending util call Verdict
+func (q *Queue) Read(b []byte) (id uint32, n int, err error) {
+	for {
+		for len(q.pending) > 0 {
+			m := q.pending[0]
+			q.pending = q.pending[1:]
+			if m.Header.Type != unix.NFNL_SUBSYS_QUEUE<<8|nfqnlMsgPacket || len(m.Data) < 4 {
+				continue
+			}
+
+			attrs, err := ParseAttrs(m.Data[4:])
+			if err != nil {
+				return 0, 0, err
+			}
+			var payload []byte
+			for _, a := range attrs {
+				switch a.Type {
+				case nfqaPacketHdr:
+					if len(a.Value) >= 4 {
+						id = binary.BigEndian.Uint32(a.Value)
+					}
+				case nfqaPayload:
+					payload = a.Value
+				}
+			}
+
+			n = copy(b, payload)
+			if n < len(payload) {
+				return id, n, errors.WithStack(unix.EMSGSIZE)
+			}
+			return id, n, nil
+		}
+
+		q.pending, err = q.conn.Recv()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// Verdict set verdict of the packet
+func (q *Queue) Verdict(id uint32, verdict uint32) error {
+	b := make([]byte, 8) // nfqnl_msg_verdict_hdr
+	binary.BigEndian.PutUint32(b[0:], verdict)
+	binary.BigEndian.PutUint32(b[4:], id)
+
+	_, err := q.conn.Send(unix.NFNL_SUBSYS_QUEUE<<8|nfqnlMsgVerdict, 0, q.nfgenmsg(), BytesAttr(nfqaVerdictHdr, b))
+	return err
+}
+
+func (q *Queue) close(cause error) error {
+	// queue will be unbind when socket closed
+	if q.conn != nil {
+		if err := q.conn.Close(); err != nil && cause == nil {
+			cause = err
+		}
+	}
+	return cause
+}
+
+func (q *Queue) Close() error { return q.close(nil) }
@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+type Route struct {
+	Dest      netip.Prefix
+	Gateway   netip.Addr // optional
+	Src       netip.Addr // optional, prefer source address
+	Interface uint32
+	Table     uint32 // default main table
+}
+
+func (r Route) String() string {
+	return r.Dest.String()
+}
+
+func AddRoute(r Route) error {
+	return r.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+}
+
+func DelRoute(r Route) error {
+	return r.request(unix.RTM_DELROUTE, 0)
+}
+
+func (r Route) request(typ, flags uint16) error {
+	if !r.Dest.IsValid() {
+		return errors.Errorf("invalid route destination %s", r.Dest.String())
+	}
+	table := r.Table
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+
+	msg := unix.RtMsg{
+		Family:   family(r.Dest.Addr()),
+		Dst_len:  uint8(r.Dest.Bits()),
+		Table:    unix.RT_TABLE_UNSPEC,
+		Protocol: unix.RTPROT_BOOT,
+		Scope:    unix.RT_SCOPE_UNIVERSE,
+		Type:     unix.RTN_UNICAST,
+	}
+	if !r.Gateway.IsValid() {
+		msg.Scope = unix.RT_SCOPE_LINK
+	}
+
+	attrs := []Attr{
+		BytesAttr(unix.RTA_DST, r.Dest.Masked().Addr().AsSlice()),
+		U32Attr(unix.RTA_TABLE, table),
+	}
+	if r.Interface != 0 {
+		attrs = append(attrs, U32Attr(unix.RTA_OIF, r.Interface))
+	}
+	if r.Gateway.IsValid() {
+		attrs = append(attrs, BytesAttr(unix.RTA_GATEWAY, r.Gateway.AsSlice()))
+	}
+	if r.Src.IsValid() {
+		attrs = append(attrs, BytesAttr(unix.RTA_PREFSRC, r.Src.AsSlice()))
+	}
+
+	body := unsafe.Slice((*byte)(unsafe.Pointer(&msg)), unix.SizeofRtMsg)
+	_, err := Request(unix.NETLINK_ROUTE, typ, flags, body, attrs...)
+	return errors.WithMessage(err, r.String())
+}
+
+// RouteGet get the source address and interface that kernel select for the
+// flow, like `ip route get {dst} ipproto {proto} sport {sport}`, so the policy
+// routing rules match source port be applied.
+func RouteGet(dst netip.Addr, proto uint8, sport uint16) (src netip.Addr, ifindex uint32, err error) {
+	msg := unix.RtMsg{
+		Family:  family(dst),
+		Dst_len: uint8(dst.BitLen()),
+	}
+	attrs := []Attr{BytesAttr(unix.RTA_DST, dst.AsSlice())}
+	if proto != 0 {
+		attrs = append(attrs, U8Attr(unix.RTA_IP_PROTO, proto))
+	}
+	if sport != 0 {
+		attrs = append(attrs, BytesAttr(unix.RTA_SPORT, binary.BigEndian.AppendUint16(nil, sport)))
+	}
+
+	body := unsafe.Slice((*byte)(unsafe.Pointer(&msg)), unix.SizeofRtMsg)
+	msgs, err := Request(unix.NETLINK_ROUTE, unix.RTM_GETROUTE, 0, body, attrs...)
+	if err != nil {
+		return netip.Addr{}, 0, errors.WithMessage(err, dst.String())
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		attrs, err := ParseAttrs(m.Data[unix.SizeofRtMsg:])
+		if err != nil {
+			return netip.Addr{}, 0, err
+		}
+		for _, a := range attrs {
+			switch a.Type {
+			case unix.RTA_PREFSRC:
+				src, _ = netip.AddrFromSlice(a.Value)
+			case unix.RTA_OIF:
+				if len(a.Value) >= 4 {
+					ifindex = binary.NativeEndian.Uint32(a.Value)
+				}
+			}
+		}
+		if src.IsValid() {
+			return src, ifindex, nil
+		}
+	}
+	return netip.Addr{}, 0, errors.Errorf("no route to %s", dst.String())
+}
+
+// Rule policy routing rule, like `ip rule`
+type Rule struct {
+	Family   uint8 // unix.AF_INET or unix.AF_INET6
+	Priority uint32
+	Table    uint32
+	Invert   bool
+
+	Mark    uint32 // optional
+	Proto   uint8  // optional, ip protocol
+	SrcPort uint16 // optional, require Proto
+
+	// SuppressDefault reject default route (prefix length 0) of the table,
+	// same as `suppress_prefixlength 0`
+	SuppressDefault bool
+}
+
+// fib_rule_hdr
+type fibRuleHdr struct {
+	family, dstLen, srcLen, tos uint8
+	table, res1, res2, action   uint8
+	flags                       uint32
+}
+
+const fibRuleInvert = 0x2
+
+func AddRule(r Rule) error {
+	return r.request(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+}
+
+func DelRule(r Rule) error {
+	return r.request(unix.RTM_DELRULE, 0)
+}
+
+func (r Rule) request(typ, flags uint16) error {
+	hdr := fibRuleHdr{
+		family: r.Family,
+		table:  unix.RT_TABLE_UNSPEC,
+		action: unix.FR_ACT_TO_TBL,
+	}
+	if hdr.family == 0 {
+		hdr.family = unix.AF_INET
+	}
+	if r.Invert {
+		hdr.flags |= fibRuleInvert
+	}
+
+	attrs := []Attr{
+		U32Attr(unix.FRA_PRIORITY, r.Priority),
+		U32Attr(unix.FRA_TABLE, r.Table),
+	}
+	if r.Mark != 0 {
+		attrs = append(attrs, U32Attr(unix.FRA_FWMARK, r.Mark))
+	}
+	if r.Proto != 0 {
+		attrs = append(attrs, U8Attr(unix.FRA_IP_PROTO, r.Proto))
+	}
+	if r.SrcPort != 0 {
+		// fib_rule_port_range
+		b := make([]byte, 4)
+		binary.NativeEndian.PutUint16(b[0:], r.SrcPort)
+		binary.NativeEndian.PutUint16(b[2:], r.SrcPort)
+		attrs = append(attrs, BytesAttr(unix.FRA_SPORT_RANGE, b))
+	}
+	if r.SuppressDefault {
+		attrs = append(attrs, U32Attr(unix.FRA_SUPPRESS_PREFIXLEN, 0))
+	}
+
+	body := unsafe.Slice((*byte)(unsafe.Pointer(&hdr)), unsafe.Sizeof(hdr))
+	_, err := Request(unix.NETLINK_ROUTE, typ, flags, body, attrs...)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,220 @@
+//go:build linux
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+type EventType uint8
+
+const (
+	LinkChanged EventType = iota + 1
+	LinkRemoved
+	AddrAdded
+	AddrRemoved
+	RouteAdded
+	RouteRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case LinkChanged:
+		return "link-changed"
+	case LinkRemoved:
+		return "link-removed"
+	case AddrAdded:
+		return "addr-added"
+	case AddrRemoved:
+		return "addr-removed"
+	case RouteAdded:
+		return "route-added"
+	case RouteRemoved:
+		return "route-removed"
+	default:
+		return fmt.Sprintf("unknown(%d)", t)
+	}
+}
+
+// Event network change notified by kernel
+type Event struct {
+	Type      EventType
+	Interface uint32 // interface index
+
+	Up   bool         // link event, interface is running
+	Addr netip.Prefix // address event: interface address, route event: destination
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case LinkChanged, LinkRemoved:
+		return fmt.Sprintf("%s if:%d up:%t", e.Type, e.Interface, e.Up)
+	default:
+		return fmt.Sprintf("%s if:%d %s", e.Type, e.Interface, e.Addr)
+	}
+}
+
+// Default route event of default route
+func (e Event) Default() bool {
+	return (e.Type == RouteAdded || e.Type == RouteRemoved) && e.Addr.IsValid() && e.Addr.Bits() == 0
+}
+
+// Watcher watch interface, address and main table route changes
+type Watcher struct {
+	conn    *Conn
+	file    *os.File // conn's nonblock fd in runtime poller
+	raw     syscall.RawConn
+	pending []syscall.NetlinkMessage
+}
+
+func Watch() (*Watcher, error) {
+	const groups = unix.RTMGRP_LINK |
+		unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+		unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+
+	c, err := Dial(unix.NETLINK_ROUTE, groups)
+	if err != nil {
+		return nil, err
+	}
+	// recvfrom not be interrupted by close fd, and the fd number maybe reused, so
+	// wait by runtime poller, that Close unblock Next and release fd after Next
+	// returned.
+	if err := unix.SetNonblock(c.fd, true); err != nil {
+		c.Close()
+		return nil, errors.WithStack(err)
+	}
+	f := os.NewFile(uintptr(c.fd), "netlink-watch")
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &Watcher{conn: c, file: f, raw: raw}, nil
+}
+
+// Next block until next event
+func (w *Watcher) Next() (Event, error) {
+	for {
+		for len(w.pending) > 0 {
+			m := w.pending[0]
+			w.pending = w.pending[1:]
+			if e, ok := parseEvent(m); ok {
+				return e, nil
+			}
+		}
+
+		var err error
+		if rerr := w.raw.Read(func(uintptr) bool {
+			w.pending, err = w.conn.Recv()
+			return !errors.Is(err, unix.EAGAIN)
+		}); rerr != nil {
+			return Event{}, errors.WithStack(rerr) // closed
+		}
+		if errors.Is(err, unix.EINTR) {
+			continue
+		} else if errors.Is(err, unix.ENOBUFS) {
+			// kernel dropped notifies, caller should resync
+			w.pending = nil
+			return Event{Type: LinkChanged}, nil
+		} else if err != nil {
+			return Event{}, err
+		}
+	}
+}
+
+func parseEvent(m syscall.NetlinkMessage) (e Event, ok bool) {
+	switch m.Header.Type {
+	case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+		if len(m.Data) < unix.SizeofIfInfomsg {
+			return e, false
+		}
+		msg := (*unix.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		e.Type, e.Interface = LinkChanged, uint32(msg.Index)
+		if m.Header.Type == unix.RTM_DELLINK {
+			e.Type = LinkRemoved
+		}
+		e.Up = msg.Flags&unix.IFF_RUNNING != 0
+		return e, true
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+		if len(m.Data) < unix.SizeofIfAddrmsg {
+			return e, false
+		}
+		msg := (*unix.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		e.Type, e.Interface = AddrAdded, msg.Index
+		if m.Header.Type == unix.RTM_DELADDR {
+			e.Type = AddrRemoved
+		}
+		attrs, err := ParseAttrs(m.Data[unix.SizeofIfAddrmsg:])
+		if err != nil {
+			return e, false
+		}
+		for _, a := range attrs {
+			// IFA_LOCAL is local address of point-to-point interface
+			if a.Type == unix.IFA_LOCAL || (a.Type == unix.IFA_ADDRESS && !e.Addr.IsValid()) {
+				if addr, ok := netip.AddrFromSlice(a.Value); ok {
+					e.Addr = netip.PrefixFrom(addr, int(msg.Prefixlen))
+				}
+			}
+		}
+		return e, true
+	case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+		if len(m.Data) < unix.SizeofRtMsg {
+			return e, false
+		}
+		msg := (*unix.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		e.Type = RouteAdded
+		if m.Header.Type == unix.RTM_DELROUTE {
+			e.Type = RouteRemoved
+		}
+		attrs, err := ParseAttrs(m.Data[unix.SizeofRtMsg:])
+		if err != nil {
+			return e, false
+		}
+		var table = uint32(msg.Table)
+		var dst []byte
+		for _, a := range attrs {
+			switch a.Type {
+			case unix.RTA_TABLE:
+				if len(a.Value) >= 4 {
+					table = binary.NativeEndian.Uint32(a.Value)
+				}
+			case unix.RTA_OIF:
+				if len(a.Value) >= 4 {
+					e.Interface = binary.NativeEndian.Uint32(a.Value)
+				}
+			case unix.RTA_DST:
+				dst = a.Value
+			}
+		}
+		if table != unix.RT_TABLE_MAIN {
+			return e, false // e.g. tun capture's table
+		}
+		if dst == nil {
+			if msg.Family == unix.AF_INET {
+				dst = make([]byte, 4)
+			} else {
+				dst = make([]byte, 16)
+			}
+		}
+		addr, ok := netip.AddrFromSlice(dst)
+		if !ok {
+			return e, false
+		}
+		e.Addr = netip.PrefixFrom(addr, int(msg.Dst_len))
+		return e, true
+	default:
+		return e, false
+	}
+}
+
+// Close unblock Next, the fd be released after Next returned
+func (w *Watcher) Close() error { return errors.WithStack(w.file.Close()) }
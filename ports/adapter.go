@@ -37,6 +37,25 @@ func (a *Adapter) GetPort(proto tcpip.TransportProtocolNumber, remote netip.Addr
 		return 0, errors.Errorf("invalid remote address %s", remote)
 	}
 
+	if proto == 0 || proto > 0xff {
+		return 0, errors.Errorf("invalid transport protocol %d", proto)
+	} else if !portable(proto) {
+		// generic ip protocol without port, the remote-addr can't be reused
+		pk := portKey{proto: uint8(proto)}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if as := a.ports[pk]; as == nil {
+			as = &AddrSet{}
+			as.Add(remote)
+			a.ports[pk] = as
+		} else if as.Has(remote) {
+			return 0, errors.Errorf("protocol %d remote %s is in use", proto, remote.Addr())
+		} else {
+			as.Add(remote)
+		}
+		return 0, nil
+	}
+
 	// try reuse alloced port, require remote-addr differently
 	a.mu.RLock()
 	for k, v := range a.ports {
@@ -64,6 +83,11 @@ func (a *Adapter) GetPort(proto tcpip.TransportProtocolNumber, remote netip.Addr
 			if err != nil {
 				return 0, err
 			}
+		case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+			port, err = a.mgr.GetEchoID()
+			if err != nil {
+				return 0, err
+			}
 		default:
 			return 0, errors.Errorf("unknown transport protocol %d", proto)
 		}
@@ -103,12 +127,14 @@ func (a *Adapter) DelPort(proto tcpip.TransportProtocolNumber, port uint16, remo
 	}
 	a.mu.Unlock()
 
-	if notuse {
+	if notuse && portable(proto) {
 		switch proto {
 		case header.TCPProtocolNumber:
 			return a.mgr.DelTCPPort(port)
 		case header.UDPProtocolNumber:
 			return a.mgr.DelUDPPort(port)
+		case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+			return a.mgr.DelEchoID(port)
 		default:
 			return errors.Errorf("unknown transport protocol %d", proto)
 		}
@@ -118,6 +144,17 @@ func (a *Adapter) DelPort(proto tcpip.TransportProtocolNumber, port uint16, remo
 
 func (a *Adapter) Addr() netip.Addr { return a.mgr.Addr() }
 
+// portable protocol has local port(or icmp echo identifier) to alloc
+func portable(proto tcpip.TransportProtocolNumber) bool {
+	switch proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber,
+		header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		return true
+	default:
+		return false
+	}
+}
+
 func (a *Adapter) Close() (err error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -129,6 +166,8 @@ func (a *Adapter) Close() (err error) {
 			e = a.mgr.DelTCPPort(pk.loaclPort)
 		case uint8(header.UDPProtocolNumber):
 			e = a.mgr.DelUDPPort(pk.loaclPort)
+		case uint8(header.ICMPv4ProtocolNumber), uint8(header.ICMPv6ProtocolNumber):
+			e = a.mgr.DelEchoID(pk.loaclPort)
 		default:
 		}
 		if e != nil {
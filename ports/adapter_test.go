@@ -77,11 +77,31 @@ func Test_Port_Adapter(t *testing.T) {
 			require.NoError(t, ap.Close())
 		}()
 
-		p1, err := ap.GetPort(123, addr1)
+		p1, err := ap.GetPort(0x100, addr1)
 		require.Error(t, err)
 		require.Zero(t, p1)
 	})
 
+	t.Run("generic-proto", func(t *testing.T) {
+		ap := ports.NewAdapter(test.LocIP())
+		defer func() {
+			require.NoError(t, ap.Close())
+		}()
+		const gre = 47
+		remote := netip.AddrPortFrom(addr1.Addr(), 0)
+
+		p1, err := ap.GetPort(gre, remote)
+		require.NoError(t, err)
+		require.Zero(t, p1)
+
+		_, err = ap.GetPort(gre, remote)
+		require.Error(t, err)
+
+		require.NoError(t, ap.DelPort(gre, 0, remote))
+		_, err = ap.GetPort(gre, remote)
+		require.NoError(t, err)
+	})
+
 	t.Run("add-invalid-addr", func(t *testing.T) {
 		ap := ports.NewAdapter(test.LocIP())
 		defer func() {
@@ -1,12 +1,14 @@
 package ports
 
 import (
+	"math/rand"
 	"net"
 	"net/netip"
 	"sync"
 
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,6 +20,7 @@ func NewMgr(addr netip.Addr) *Manager {
 	var mgr = &Manager{
 		tcp: map[uint16]*net.TCPListener{},
 		udp: map[uint16]*net.UDPConn{},
+		ids: map[uint16]struct{}{},
 	}
 	mgr.tcpAddr = &net.TCPAddr{IP: addr.AsSlice()}
 	mgr.udpAddr = &net.UDPAddr{IP: addr.AsSlice()}
@@ -33,6 +36,8 @@ type Manager struct {
 
 	udpAddr *net.UDPAddr
 	udp     map[uint16]*net.UDPConn
+
+	ids map[uint16]struct{} // icmp echo identifier
 }
 
 func (p *Manager) GetTCPPort() (uint16, error) {
@@ -109,6 +114,30 @@ func (p *Manager) DelUDPPort(port uint16) error {
 	return l.Close()
 }
 
+// GetEchoID get a icmp echo identifier, only unique in current process
+func (p *Manager) GetEchoID() (uint16, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.ids) >= 0xffff {
+		return 0, errors.New("icmp echo identifier exhausted")
+	}
+	for {
+		id := uint16(rand.Uint32())
+		if _, has := p.ids[id]; !has && id != 0 {
+			p.ids[id] = struct{}{}
+			return id, nil
+		}
+	}
+}
+
+func (p *Manager) DelEchoID(id uint16) error {
+	p.Lock()
+	delete(p.ids, id)
+	p.Unlock()
+	return nil
+}
+
 func (p *Manager) Addr() netip.Addr {
 	addr, ok := netip.AddrFromSlice(p.tcpAddr.IP)
 	if !ok {
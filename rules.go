@@ -0,0 +1,338 @@
+package fatun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Action split tunneling action
+type Action uint8
+
+const (
+	Tunnel Action = iota
+	Direct
+	Drop
+)
+
+func (a Action) String() string {
+	switch a {
+	case Tunnel:
+		return "tunnel"
+	case Direct:
+		return "direct"
+	case Drop:
+		return "drop"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+func (a Action) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+func (a *Action) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "tunnel":
+		*a = Tunnel
+	case "direct":
+		*a = Direct
+	case "drop":
+		*a = Drop
+	default:
+		return errors.Errorf("invalid action %s", string(b))
+	}
+	return nil
+}
+
+// Direction packet direction relative to client process, zero value match both
+type Direction uint8
+
+const (
+	Outbound Direction = 1
+	Inbound  Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case 0:
+		return "any"
+	case Outbound:
+		return "outbound"
+	case Inbound:
+		return "inbound"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(d))
+	}
+}
+
+func (d Direction) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+func (d *Direction) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "", "any":
+		*d = 0
+	case "outbound":
+		*d = Outbound
+	case "inbound":
+		*d = Inbound
+	default:
+		return errors.Errorf("invalid direction %s", string(b))
+	}
+	return nil
+}
+
+// Proto transport protocol, zero value match any protocol
+type Proto tcpip.TransportProtocolNumber
+
+func (p Proto) MarshalText() ([]byte, error) {
+	return []byte(protostr(tcpip.TransportProtocolNumber(p))), nil
+}
+func (p *Proto) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "", "any":
+		*p = 0
+	case "tcp":
+		*p = Proto(header.TCPProtocolNumber)
+	case "udp":
+		*p = Proto(header.UDPProtocolNumber)
+	case "icmp":
+		*p = Proto(header.ICMPv4ProtocolNumber)
+	case "icmp6":
+		*p = Proto(header.ICMPv6ProtocolNumber)
+	case "gre":
+		*p = 47
+	case "esp":
+		*p = 50
+	case "sctp":
+		*p = 132
+	default:
+		n, err := strconv.ParseUint(string(b), 10, 8)
+		if err != nil {
+			return errors.Errorf("invalid protocol %s", string(b))
+		}
+		*p = Proto(n)
+	}
+	return nil
+}
+
+// PortRange closed port range
+type PortRange struct {
+	From, To uint16
+}
+
+func (p PortRange) Contains(port uint16) bool { return p.From <= port && port <= p.To }
+
+func (p PortRange) MarshalText() ([]byte, error) {
+	if p.From == p.To {
+		return []byte(strconv.Itoa(int(p.From))), nil
+	}
+	return []byte(fmt.Sprintf("%d-%d", p.From, p.To)), nil
+}
+func (p *PortRange) UnmarshalText(b []byte) error {
+	from, to, found := strings.Cut(string(b), "-")
+	if !found {
+		to = from
+	}
+	f, err1 := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	t, err2 := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+	if err1 != nil || err2 != nil || f > t {
+		return errors.Errorf("invalid port range %s", string(b))
+	}
+	p.From, p.To = uint16(f), uint16(t)
+	return nil
+}
+
+// Rule split tunneling rule, empty field match any
+type Rule struct {
+	Action    Action         `json:"action"`
+	Dst       []netip.Prefix `json:"dst,omitempty"`   // flow's remote address
+	Ports     []PortRange    `json:"ports,omitempty"` // flow's remote port
+	Proto     Proto          `json:"proto,omitempty"`
+	Process   []string       `json:"process,omitempty"` // process name
+	Domain    []string       `json:"domain,omitempty"`  // remote address's domain pattern, see MatchDomain
+	Direction Direction      `json:"direction,omitempty"`
+}
+
+// ProcessMapping mapping local address to process name
+type ProcessMapping interface {
+	Name(laddr netip.AddrPort, proto uint8) (string, error)
+}
+
+// flow match context, process and domain be queried lazily
+type flowInfo struct {
+	local, remote netip.AddrPort
+	proto         tcpip.TransportProtocolNumber
+	dir           Direction
+
+	rules   *Rules
+	name    string // sniffed server name
+	process *string
+	domains *[]string
+	matched string // domain matched by rule
+}
+
+func (f *flowInfo) Process() string {
+	if f.process == nil {
+		var name string
+		if f.rules.Mapping != nil {
+			name, _ = f.rules.Mapping.Name(f.local, uint8(f.proto))
+		}
+		f.process = &name
+	}
+	return *f.process
+}
+
+// Domains get sniffed server name, or the domains that resolved to remote address
+func (f *flowInfo) Domains() []string {
+	if f.domains == nil {
+		var domains []string
+		if f.name != "" {
+			domains = []string{f.name}
+		} else if f.rules.DNS != nil {
+			domains = f.rules.DNS.Lookup(f.remote.Addr())
+		}
+		f.domains = &domains
+	}
+	return *f.domains
+}
+
+func (r *Rule) match(f *flowInfo) bool {
+	if r.Direction != 0 && r.Direction != f.dir {
+		return false
+	}
+	if r.Proto != 0 && tcpip.TransportProtocolNumber(r.Proto) != f.proto {
+		return false
+	}
+	if len(r.Dst) > 0 && !slices.ContainsFunc(r.Dst, func(p netip.Prefix) bool {
+		return p.Contains(f.remote.Addr())
+	}) {
+		return false
+	}
+	if len(r.Ports) > 0 && !slices.ContainsFunc(r.Ports, func(p PortRange) bool {
+		return p.Contains(f.remote.Port())
+	}) {
+		return false
+	}
+	if len(r.Process) > 0 && !slices.Contains(r.Process, f.Process()) {
+		return false
+	}
+	if len(r.Domain) > 0 {
+		i := slices.IndexFunc(f.Domains(), func(d string) bool {
+			return slices.ContainsFunc(r.Domain, func(p string) bool { return MatchDomain(p, d) })
+		})
+		if i < 0 {
+			return false
+		}
+		f.matched = f.Domains()[i]
+	}
+	return true
+}
+
+// Rules split tunneling rule engine, first match wins, not matched flow will be tunneled
+type Rules struct {
+	// Mapping required by rule with process
+	Mapping ProcessMapping
+
+	// DNS required by rule with domain
+	DNS *DNSCache
+
+	rules atomic.Pointer[[]Rule]
+
+	mu       sync.Mutex
+	replaced []func() // notified after rules replaced
+}
+
+func NewRules(rules ...Rule) *Rules {
+	var r = &Rules{}
+	r.Replace(rules)
+	return r
+}
+
+// LoadRules load rules from json file
+func LoadRules(file string) ([]Rule, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rules, nil
+}
+
+// Load replace rules by json file
+func (r *Rules) Load(file string) error {
+	rules, err := LoadRules(file)
+	if err != nil {
+		return err
+	}
+	r.Replace(rules)
+	return nil
+}
+
+// Replace atomic replace rules, is concurrent safe, existing flows also follow
+// the new rules.
+func (r *Rules) Replace(rules []Rule) {
+	rules = slices.Clone(rules)
+	r.rules.Store(&rules)
+
+	r.mu.Lock()
+	fns := slices.Clone(r.replaced)
+	r.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// onReplace register fn, that invalidate per-flow action cache after rules replaced
+func (r *Rules) onReplace(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replaced = append(r.replaced, fn)
+}
+
+func (r *Rules) Rules() []Rule {
+	return slices.Clone(*r.rules.Load())
+}
+
+// Match get the flow's action, the Session is the packet's, for inbound packet, the
+// Session.Src is remote address.
+func (r *Rules) Match(s Session, dir Direction) Action {
+	return r.MatchName(s, dir, "")
+}
+
+// MatchName similar to Match, name is the flow's server name, that be used by rule
+// with domain, empty name will fallback to DNS record.
+func (r *Rules) MatchName(s Session, dir Direction, name string) Action {
+	a, _ := r.decide(s, dir, name)
+	return a
+}
+
+// decide similar to MatchName, also return the domain matched by the decided
+// rule, it's empty if the rule without domain
+func (r *Rules) decide(s Session, dir Direction, name string) (Action, string) {
+	if r == nil {
+		return Tunnel, ""
+	}
+
+	var f = &flowInfo{local: s.Src, remote: s.Dst, proto: s.Proto, dir: dir, rules: r, name: name}
+	if dir == Inbound {
+		f.local, f.remote = s.Dst, s.Src
+	}
+	for _, e := range *r.rules.Load() {
+		if e.match(f) {
+			return e.Action, f.matched
+		}
+	}
+	return Tunnel, ""
+}
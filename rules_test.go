@@ -0,0 +1,72 @@
+package fatun_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lysShub/fatun"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type mockMapping map[netip.AddrPort]string
+
+func (m mockMapping) Name(laddr netip.AddrPort, proto uint8) (string, error) {
+	return m[laddr], nil
+}
+
+func Test_Rules_Match(t *testing.T) {
+	var (
+		local = netip.MustParseAddrPort("192.168.0.2:1234")
+		s     = func(dst string) fatun.Session {
+			return fatun.Session{Src: local, Proto: header.TCPProtocolNumber, Dst: netip.MustParseAddrPort(dst)}
+		}
+	)
+
+	rules := fatun.NewRules(
+		fatun.Rule{Action: fatun.Drop, Ports: []fatun.PortRange{{From: 25, To: 25}}},
+		fatun.Rule{Action: fatun.Direct, Dst: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, Ports: []fatun.PortRange{{From: 8000, To: 9000}}},
+		fatun.Rule{Action: fatun.Direct, Process: []string{"curl"}, Direction: fatun.Outbound},
+	)
+	require.Equal(t, fatun.Drop, rules.Match(s("8.8.8.8:25"), fatun.Outbound))
+	require.Equal(t, fatun.Direct, rules.Match(s("10.1.1.1:8080"), fatun.Outbound))
+	require.Equal(t, fatun.Tunnel, rules.Match(s("10.1.1.1:80"), fatun.Outbound))
+	require.Equal(t, fatun.Tunnel, rules.Match(s("11.1.1.1:8080"), fatun.Outbound))
+
+	// inbound Session's remote address is Src
+	in := fatun.Session{Src: netip.MustParseAddrPort("8.8.8.8:25"), Proto: header.TCPProtocolNumber, Dst: local}
+	require.Equal(t, fatun.Drop, rules.Match(in, fatun.Inbound))
+
+	require.Equal(t, fatun.Tunnel, rules.Match(s("1.1.1.1:443"), fatun.Outbound))
+	rules.Mapping = mockMapping{local: "curl"}
+	require.Equal(t, fatun.Direct, rules.Match(s("1.1.1.1:443"), fatun.Outbound))
+
+	rules.Replace(nil)
+	require.Equal(t, fatun.Tunnel, rules.Match(s("8.8.8.8:25"), fatun.Outbound))
+}
+
+func Test_Rules_Load(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[
+		{"action": "drop", "proto": "udp", "ports": ["53"]},
+		{"action": "direct", "dst": ["10.0.0.0/8", "fd00::/8"], "ports": ["80", "8000-9000"], "direction": "outbound"}
+	]`), 0644))
+
+	rules, err := fatun.LoadRules(file)
+	require.NoError(t, err)
+	require.Equal(t, []fatun.Rule{
+		{Action: fatun.Drop, Proto: fatun.Proto(header.UDPProtocolNumber), Ports: []fatun.PortRange{{From: 53, To: 53}}},
+		{
+			Action:    fatun.Direct,
+			Dst:       []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")},
+			Ports:     []fatun.PortRange{{From: 80, To: 80}, {From: 8000, To: 9000}},
+			Direction: fatun.Outbound,
+		},
+	}, rules)
+
+	r := fatun.NewRules()
+	require.NoError(t, r.Load(file))
+	require.Equal(t, rules, r.Rules())
+}
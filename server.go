@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/lysShub/fatun/checksum"
@@ -20,6 +21,7 @@ import (
 	"github.com/lysShub/netkit/packet"
 	"github.com/lysShub/netkit/pcap"
 	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -43,6 +45,11 @@ type Server struct {
 
 	Sender Sender
 
+	// Protocols allowed generic ip protocols without port, e.g. gre/sctp/esp,
+	// default only tcp/udp/icmp be forwarded. one server address of generic
+	// protocol can only be used by one client at same time.
+	Protocols []tcpip.TransportProtocolNumber
+
 	PcapSender *pcap.Pcap
 
 	peer     conn.Peer
@@ -78,7 +85,7 @@ func NewServer[P conn.Peer](opts ...func(*Server)) (*Server, error) {
 	}
 
 	if s.Sender == nil {
-		s.Sender, err = NewDefaultSender(s.Listener.Addr())
+		s.Sender, err = NewDefaultSender(s.Listener.Addr(), s.Protocols...)
 		if err != nil {
 			return s, s.close(err)
 		}
@@ -138,14 +145,19 @@ func (s *Server) serveConn(conn conn.Conn) (_ error) {
 	var (
 		client = conn.RemoteAddr()
 		pkt    = packet.Make(0, s.MaxRecvBuff)
-		t      header.Transport
 		peer   = s.peer.Builtin().Reset(0, netip.IPv4Unspecified())
 	)
 	defer func() {
 		conn.Close()
-		s.Logger.Info("close connect", slog.String("client", client.String()))
+		s.Logger.Info("close connect", slog.String("client", client.String()), slog.String("identity", conn.Identity().String()))
 	}()
 
+	if _, err := conn.BuiltinConn(s.srvCtx); err != nil {
+		s.Logger.Error(err.Error(), errorx.Trace(err), slog.String("client", client.String()))
+		return nil
+	}
+	s.Logger.Info("accept connect", slog.String("client", client.String()), slog.String("identity", conn.Identity().String()))
+
 	for {
 		err := conn.Recv(peer, pkt.Sets(64, 0xffff))
 		if err != nil {
@@ -158,20 +170,47 @@ func (s *Server) serveConn(conn conn.Conn) (_ error) {
 			}
 		}
 
+		if addr := conn.RemoteAddr(); addr != client {
+			n := s.Links.Migrate(conn, client.Addr(), addr.Addr())
+			s.Logger.Info("client migrated",
+				slog.String("from", client.String()), slog.String("to", addr.String()),
+				slog.Int("links", n), slog.String("identity", conn.Identity().String()))
+			client = addr
+		}
+
+		if peer.Peer().Is6() {
+			// todo: EthSender is ipv4 only, require ipv6 address, neighbor and bpf
+			s.Logger.Debug("not support ipv6 peer", slog.String("peer", peer.String()), slog.String("client", client.String()))
+			continue
+		}
+
+		var srcPort, dstPort uint16
 		switch peer.Protocol() {
 		case header.TCPProtocolNumber:
-			t = header.TCP(pkt.Bytes())
+			t := header.TCP(pkt.Bytes())
+			srcPort, dstPort = t.SourcePort(), t.DestinationPort()
 		case header.UDPProtocolNumber:
-			t = header.UDP(pkt.Bytes())
+			t := header.UDP(pkt.Bytes())
+			srcPort, dstPort = t.SourcePort(), t.DestinationPort()
+		case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+			id, request, err := echoIdent(peer.Protocol(), pkt.Bytes())
+			if err != nil || !request {
+				// client controlled, avoid flood log
+				s.Logger.Debug("not support icmp message", slog.String("client", client.String()))
+				continue
+			}
+			srcPort = id // echo identifier as port
 		default:
-			s.Logger.Warn(fmt.Sprintf("not support protocol %d", peer.Protocol()), errorx.CallTrace())
-			continue
+			if !links.IsGeneric(peer.Protocol()) || !slices.Contains(s.Protocols, peer.Protocol()) {
+				s.Logger.Debug(fmt.Sprintf("not support protocol %d", peer.Protocol()), slog.String("client", client.String()))
+				continue
+			}
 		}
 
 		up := links.Uplink{
-			Process: netip.AddrPortFrom(conn.RemoteAddr().Addr(), t.SourcePort()),
+			Process: netip.AddrPortFrom(client.Addr(), srcPort),
 			Proto:   peer.Protocol(),
-			Server:  netip.AddrPortFrom(peer.Peer(), t.DestinationPort()),
+			Server:  netip.AddrPortFrom(peer.Peer(), dstPort),
 		}
 		localPort, has := s.Links.Uplink(up)
 		if !has {
@@ -187,6 +226,20 @@ func (s *Server) serveConn(conn conn.Conn) (_ error) {
 			Proto:  up.Proto,
 			Local:  netip.AddrPortFrom(s.Listener.Addr().Addr(), localPort),
 		}
+		if has {
+			if owner, _, ok := s.Links.Downlink(down); ok && owner != conn {
+				if !resumedFrom(conn, owner) {
+					s.Logger.Warn("link conflict with other client", slog.String("link", up.String()),
+						slog.String("client", client.String()), slog.String("identity", conn.Identity().String()))
+					continue
+				}
+				// client reconnected by resumed session, previous conn is stale
+				n := s.Links.Resume(owner, conn)
+				owner.Close()
+				s.Logger.Info("client links resumed", slog.String("client", client.String()),
+					slog.Int("links", n), slog.String("session", conn.Session().String()))
+			}
+		}
 		ip := checksum.Server(pkt, down)
 
 		if s.PcapSender != nil {
@@ -200,6 +253,12 @@ func (s *Server) serveConn(conn conn.Conn) (_ error) {
 	}
 }
 
+// resumedFrom the conn resumed the session of owner by ticket, see conn.Resumption,
+// address and identity can't prove that, they maybe shared by other clients
+func resumedFrom(c, owner conn.Conn) bool {
+	return c.Session() != conn.SessionID{} && c.Session() == owner.Session()
+}
+
 func (s *Server) recvService() (_ error) {
 	var (
 		ip   = packet.Make(s.MaxRecvBuff)
@@ -228,7 +287,9 @@ func (s *Server) recvService() (_ error) {
 
 		link, err := links.StripIP(ip)
 		if err != nil {
-			s.Logger.Warn(err.Error(), errorx.Trace(err))
+			if e := s.icmpError(ip.SetHead(old), peer); e != nil {
+				s.Logger.Warn(err.Error(), errorx.Trace(err))
+			}
 			continue
 		}
 
@@ -253,8 +314,10 @@ func (s *Server) recvService() (_ error) {
 			header.TCP(ip.Bytes()).SetDestinationPort(port)
 		case header.UDPProtocolNumber:
 			header.UDP(ip.Bytes()).SetDestinationPort(port)
+		case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+			header.ICMPv4(ip.Bytes()).SetIdent(port)
 		default:
-			return errors.Errorf("not support protocol %d", peer.Protocol())
+			// generic ip protocol without port
 		}
 		if err := conn.Send(peer, ip); err != nil {
 			// todo: 如果是已经删除的downlinker, 应该从links中删除，对于tcp，还应该回复RST
@@ -263,6 +326,60 @@ func (s *Server) recvService() (_ error) {
 	}
 }
 
+// icmpError forward icmp error message to client, the error message quote the packet of
+// tunneled flow, quoted packet's source port will be translated to client's port.
+func (s *Server) icmpError(ip *packet.Packet, peer conn.Peer) error {
+	var (
+		reporter netip.Addr
+		proto    tcpip.TransportProtocolNumber
+		hdrLen   int
+	)
+	switch header.IPVersion(ip.Bytes()) {
+	case 4:
+		hdr := header.IPv4(ip.Bytes())
+		reporter = netip.AddrFrom4(hdr.SourceAddress().As4())
+		proto, hdrLen = hdr.TransportProtocol(), int(hdr.HeaderLength())
+	case 6:
+		hdr := header.IPv6(ip.Bytes())
+		reporter = netip.AddrFrom16(hdr.SourceAddress().As16())
+		proto, hdrLen = hdr.TransportProtocol(), header.IPv6FixedHeaderSize
+	default:
+		return errors.New("invalid ip packet")
+	}
+	if len(ip.Bytes()) < hdrLen || !links.IsICMPError(proto, ip.Bytes()[hdrLen:]) {
+		return errors.New("not icmp error message")
+	}
+	old := ip.Head()
+	ip.SetHead(old + hdrLen)
+	icmp := ip.Bytes()
+
+	link, err := links.Quoted(icmp)
+	if err != nil {
+		return err
+	}
+	conn, port, has := s.Links.Downlink(link)
+	if !has {
+		return nil
+	}
+	if err := links.SetQuotedPort(icmp, port); err != nil {
+		return err
+	}
+
+	if s.PcapSender != nil {
+		err = s.PcapSender.WriteIP(ip.SetHead(old).Bytes())
+		if err != nil {
+			return err
+		}
+		ip.SetHead(old + hdrLen)
+	}
+
+	peer.Reset(proto, reporter)
+	if err := conn.Send(peer, ip); err != nil {
+		s.Logger.Warn(err.Error(), errorx.Trace(err))
+	}
+	return nil
+}
+
 // todo: optimzie
 func ifaceByAddr(laddr netip.Addr) (*net.Interface, error) {
 	ifs, err := net.Interfaces()
@@ -279,8 +396,8 @@ func ifaceByAddr(laddr netip.Addr) (*net.Interface, error) {
 			return nil, errors.WithStack(err)
 		}
 		for _, addr := range addrs {
-			if e, ok := addr.(*net.IPNet); ok && e.IP.To4() != nil {
-				if netip.AddrFrom4([4]byte(e.IP.To4())) == laddr {
+			if e, ok := addr.(*net.IPNet); ok {
+				if a, ok := netip.AddrFromSlice(e.IP); ok && a.Unmap() == laddr.Unmap() {
 					return &i, nil
 				}
 			}
@@ -17,10 +17,12 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/net/bpf"
 	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
-func NewDefaultSender(laddr netip.AddrPort) (Sender, error) {
+// NewDefaultSender create EthSender, protos is allowed generic ip protocols
+func NewDefaultSender(laddr netip.AddrPort, protos ...tcpip.TransportProtocolNumber) (Sender, error) {
 	s, err := NewETHSender(laddr.Addr())
 	if err != nil {
 		return nil, err
@@ -28,6 +30,7 @@ func NewDefaultSender(laddr netip.AddrPort) (Sender, error) {
 	if err = s.SkipPorts(
 		[]uint16{22},
 		[]uint16{laddr.Port()}, // todo: current work on udp
+		protos...,
 	); err != nil {
 		s.Close()
 		return nil, err
@@ -36,6 +39,8 @@ func NewDefaultSender(laddr netip.AddrPort) (Sender, error) {
 	return s, nil
 }
 
+// EthSender send and recv ipv4 packet by the interface's link layer, ipv6 not
+// be supported, client reject tunnel ipv6 flow.
 type EthSender struct {
 	conn *eth.ETHConn
 	to   net.HardwareAddr
@@ -82,9 +87,11 @@ func NewETHSender(laddr netip.Addr) (*EthSender, error) {
 	return s, nil
 }
 
-func (s *EthSender) SkipPorts(tcp, udp []uint16) error {
+// SkipPorts set bpf filter, skip tcp/udp packet that dst port in ports, and
+// accept generic ip protocols in protos
+func (s *EthSender) SkipPorts(tcp, udp []uint16, protos ...tcpip.TransportProtocolNumber) error {
 	var prog *unix.SockFprog
-	if rawIns, err := bpf.Assemble(bpfFilterProtoAndSkipLocalTCPPorts(tcp, udp)); err != nil {
+	if rawIns, err := bpf.Assemble(bpfFilterProtoAndSkipLocalTCPPorts(tcp, udp, protos...)); err != nil {
 		return s.close(errors.WithStack(err))
 	} else {
 		prog = &unix.SockFprog{
@@ -132,20 +139,62 @@ func (s *EthSender) Close() error { return s.close(nil) }
 
 // bpfFilterProtoAndSkipLocalTCPPorts bpf filter,
 //
-// will drop packet, that the protocol dst port in skip-ports
-func bpfFilterProtoAndSkipLocalTCPPorts(tcpSkipPorts, udpSkipPorts []uint16) (ins []bpf.Instruction) {
+// will drop packet, that the protocol dst port in skip-ports, and accept icmp echo reply/error
+// and generic ip protocols in protos
+func bpfFilterProtoAndSkipLocalTCPPorts(tcpSkipPorts, udpSkipPorts []uint16, protos ...tcpip.TransportProtocolNumber) (ins []bpf.Instruction) {
 	ins = append(ins,
 		bpfSkipPorts(syscall.IPPROTO_TCP, tcpSkipPorts)...,
 	)
 	ins = append(ins,
 		bpfSkipPorts(syscall.IPPROTO_UDP, udpSkipPorts)...,
 	)
+	ins = append(ins,
+		bpfICMPTypes(header.ICMPv4EchoReply, header.ICMPv4DstUnreachable, header.ICMPv4TimeExceeded, header.ICMPv4ParamProblem)...,
+	)
+	ins = append(ins,
+		bpfProtocols(protos...)...,
+	)
 
 	return append(ins,
 		bpf.RetConstant{Val: 0},
 	)
 }
 
+// bpfICMPTypes accept icmp message with the types
+func bpfICMPTypes(types ...header.ICMPv4Type) []bpf.Instruction {
+	var accept = []bpf.Instruction{
+		// store IPv4HdrLen regX
+		bpf.LoadMemShift{Off: 0},
+		bpf.LoadIndirect{Off: 0, Size: 1},
+	}
+	for _, e := range types {
+		accept = append(accept,
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(e), SkipTrue: 1},
+			bpf.RetConstant{Val: 0xffff},
+		)
+	}
+	accept = append(accept, bpf.RetConstant{Val: 0})
+
+	return append([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 9, Size: 1}, // IPv4ProtocolOffset
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: syscall.IPPROTO_ICMP, SkipTrue: uint8(len(accept))},
+	}, accept...)
+}
+
+// bpfProtocols accept packet with the protocols
+func bpfProtocols(protos ...tcpip.TransportProtocolNumber) []bpf.Instruction {
+	var ins = []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 9, Size: 1}, // IPv4ProtocolOffset
+	}
+	for _, e := range protos {
+		ins = append(ins,
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(e), SkipTrue: 1},
+			bpf.RetConstant{Val: 0xffff},
+		)
+	}
+	return ins
+}
+
 func bpfSkipPorts(proto uint8, ports []uint16) []bpf.Instruction {
 	slices.Sort(ports)
 	ports = slices.Compact(ports)
@@ -8,6 +8,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -17,6 +18,7 @@ func Test_bpfFilterProtoAndLocalPorts(t *testing.T) {
 		Return       uint16
 		TCPSkipPorts []uint16
 		UDPSkipPorts []uint16
+		Protocols    []tcpip.TransportProtocolNumber
 		IP           header.IPv4
 	}{
 		{
@@ -128,8 +130,8 @@ func Test_bpfFilterProtoAndLocalPorts(t *testing.T) {
 		},
 
 		{
-			Return: 0,
-			IP: header.IPv4{ // icmp
+			Return: 0xffff,
+			IP: header.IPv4{ // icmp time exceeded
 				0x45, 0xc0, 0x00, 0x38, 0x00, 0x46, 0x00, 0x00,
 				0xfa, 0x01, 0x04, 0x4b, 0x8b, 0xcb, 0x43, 0xdd,
 				0xc0, 0xa8, 0x2b, 0x23, 0x0b, 0x00, 0xec, 0x69,
@@ -139,10 +141,45 @@ func Test_bpfFilterProtoAndLocalPorts(t *testing.T) {
 				0x07, 0x47, 0x1f, 0x4a, 0x00, 0x23, 0xe1, 0xe1,
 			},
 		},
+		{
+			Return: 0xffff,
+			IP: header.IPv4{ // icmp echo reply
+				0x45, 0x00, 0x00, 0x1c, 0x00, 0x46, 0x00, 0x00,
+				0x40, 0x01, 0x00, 0x00, 0x08, 0x08, 0x08, 0x08,
+				0xc0, 0xa8, 0x2b, 0x23, 0x00, 0x00, 0xf7, 0xfe,
+				0x00, 0x01, 0x00, 0x00,
+			},
+		},
+		{
+			Return: 0,
+			IP: header.IPv4{ // gre
+				0x45, 0x00, 0x00, 0x18, 0x00, 0x46, 0x00, 0x00,
+				0x40, 0x2f, 0x00, 0x00, 0x08, 0x08, 0x08, 0x08,
+				0xc0, 0xa8, 0x2b, 0x23, 0x00, 0x00, 0x08, 0x00,
+			},
+		},
+		{
+			Return:    0xffff,
+			Protocols: []tcpip.TransportProtocolNumber{50, 47},
+			IP: header.IPv4{ // gre
+				0x45, 0x00, 0x00, 0x18, 0x00, 0x46, 0x00, 0x00,
+				0x40, 0x2f, 0x00, 0x00, 0x08, 0x08, 0x08, 0x08,
+				0xc0, 0xa8, 0x2b, 0x23, 0x00, 0x00, 0x08, 0x00,
+			},
+		},
+		{
+			Return: 0,
+			IP: header.IPv4{ // icmp redirect
+				0x45, 0x00, 0x00, 0x1c, 0x00, 0x46, 0x00, 0x00,
+				0x40, 0x01, 0x00, 0x00, 0x08, 0x08, 0x08, 0x08,
+				0xc0, 0xa8, 0x2b, 0x23, 0x05, 0x00, 0xfa, 0xff,
+				0x00, 0x00, 0x00, 0x00,
+			},
+		},
 	}
 
 	for i, e := range suits {
-		vm, err := bpf.NewVM(bpfFilterProtoAndSkipLocalTCPPorts(e.TCPSkipPorts, e.UDPSkipPorts))
+		vm, err := bpf.NewVM(bpfFilterProtoAndSkipLocalTCPPorts(e.TCPSkipPorts, e.UDPSkipPorts, e.Protocols...))
 		require.NoError(t, err, i)
 
 		n, err := vm.Run(e.IP)
@@ -6,8 +6,10 @@ package fatun
 import (
 	"errors"
 	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
 )
 
-func NewDefaultSender(laddr netip.AddrPort) (Sender, error) {
+func NewDefaultSender(laddr netip.AddrPort, protos ...tcpip.TransportProtocolNumber) (Sender, error) {
 	return nil, errors.New("windows not default sender")
 }
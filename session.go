@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/netip"
 
+	"github.com/lysShub/fatun/links"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
 	"github.com/pkg/errors"
@@ -76,8 +77,56 @@ func fromIP(ip []byte) (s Session, ipHdrLen int, err error) {
 			Proto: proto,
 			Dst:   netip.AddrPortFrom(dst, udp.DestinationPort()),
 		}, ipHdrLen, nil
+	case header.ICMPv4ProtocolNumber, header.ICMPv6ProtocolNumber:
+		id, request, err := echoIdent(proto, hdr)
+		if err != nil {
+			return Session{}, 0, err
+		}
+		// echo identifier as port of request side
+		if request {
+			return Session{
+				Src:   netip.AddrPortFrom(src, id),
+				Proto: proto,
+				Dst:   netip.AddrPortFrom(dst, 0),
+			}, ipHdrLen, nil
+		} else {
+			return Session{
+				Src:   netip.AddrPortFrom(src, 0),
+				Proto: proto,
+				Dst:   netip.AddrPortFrom(dst, id),
+			}, ipHdrLen, nil
+		}
 	default:
-		return Session{}, 0, errors.Errorf("not support  protocol %d", proto)
+		if !links.IsGeneric(proto) {
+			return Session{}, 0, errors.Errorf("not support  protocol %d", proto)
+		}
+		return Session{
+			Src:   netip.AddrPortFrom(src, 0),
+			Proto: proto,
+			Dst:   netip.AddrPortFrom(dst, 0),
+		}, ipHdrLen, nil
+	}
+}
+
+// echoIdent get icmp echo message's identifier, only support echo request/reply
+func echoIdent(proto tcpip.TransportProtocolNumber, icmp []byte) (id uint16, request bool, err error) {
+	if len(icmp) < header.ICMPv4MinimumSize {
+		return 0, false, errors.New("invalid icmp message")
+	}
+	if proto == header.ICMPv4ProtocolNumber {
+		switch typ := header.ICMPv4(icmp).Type(); typ {
+		case header.ICMPv4Echo, header.ICMPv4EchoReply:
+			return header.ICMPv4(icmp).Ident(), typ == header.ICMPv4Echo, nil
+		default:
+			return 0, false, errors.Errorf("not support icmp type %d", typ)
+		}
+	} else {
+		switch typ := header.ICMPv6(icmp).Type(); typ {
+		case header.ICMPv6EchoRequest, header.ICMPv6EchoReply:
+			return header.ICMPv6(icmp).Ident(), typ == header.ICMPv6EchoRequest, nil
+		default:
+			return 0, false, errors.Errorf("not support icmpv6 type %d", typ)
+		}
 	}
 }
 
@@ -99,6 +148,12 @@ func protostr(num tcpip.TransportProtocolNumber) string {
 		return "icmp"
 	case header.ICMPv6ProtocolNumber:
 		return "icmp6"
+	case 47:
+		return "gre"
+	case 50:
+		return "esp"
+	case 132:
+		return "sctp"
 	default:
 		return fmt.Sprintf("unknown(%d)", int(num))
 	}
@@ -0,0 +1,218 @@
+package fatun
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// SessionInfo client captured flow
+type SessionInfo struct {
+	Session Session
+	Name    string // sniffed server name, see Client.Sniff
+	Action  Action
+	Domain  string // the domain matched by rule that decided Action, by Name or Rules.DNS
+}
+
+// sessions client flow table, cache per-flow action, avoid match rules (maybe
+// lookup process) for every packet
+type sessions struct {
+	mu       sync.RWMutex
+	sessions map[Session]*session // by outbound session
+	cleaned  time.Time
+}
+
+type session struct {
+	SessionInfo
+
+	inbound  Action
+	matched  [2]bool // by direction, reset after rules replaced
+	sniffing bool
+	held     [][]byte // ip packets held until sniffed
+	heldAt   time.Time
+	last     time.Time
+}
+
+const (
+	sessionIdle = time.Minute * 2
+
+	// maxHeld max held packets count of sniffing flow
+	maxHeld = 4
+
+	// sniffTimeout max wait time of sniffing flow's first payloads, flow be decided
+	// without server name after timeout
+	sniffTimeout = time.Millisecond * 500
+)
+
+// matcher decide flow's action by server name, domain is the domain matched by
+// the decided rule, see Rules.MatchName
+type matcher func(name string) (a Action, domain string)
+
+func newSessions() *sessions {
+	return &sessions{sessions: map[Session]*session{}, cleaned: time.Now()}
+}
+
+// uplink get outbound packet's action, new flow's action decided by match, if sniff is
+// true, the flow's first payload packets will be held until server name be sniffed, then
+// the flow's action be re-decided by the name. if hold is true, the ip be held, otherwise
+// the ip and released packets should be applied action.
+//
+// notice: tcp flow's handshake packets has be sent before payload, so sniffed name can't
+// change the flow's route between tunnel and direct, except drop, tcp flow should be
+// sniffed by splicer.
+func (s *sessions) uplink(ip []byte, sess Session, sniff bool, match matcher) (a Action, hold bool, released [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, has := s.get(sess)
+	if !has {
+		e.sniffing = sniff
+	}
+	if !e.matched[0] {
+		e.Action, e.Domain = match(e.Name)
+		e.matched[0] = true
+	}
+	if !e.sniffing {
+		return e.Action, false, nil
+	}
+
+	payload := transportPayload(ip, sess.Proto)
+	if len(payload) == 0 {
+		return e.Action, false, nil // e.g. tcp handshake
+	}
+
+	var payloads = make([][]byte, 0, len(e.held)+1)
+	for _, e := range e.held {
+		payloads = append(payloads, transportPayload(e, sess.Proto))
+	}
+	name, err := Sniff(sess.Proto, append(payloads, payload)...)
+	if errors.Is(err, ErrSniffMore) && len(e.held)+1 < maxHeld {
+		if len(e.held) == 0 {
+			e.heldAt = time.Now()
+		}
+		e.held = append(e.held, slices.Clone(ip))
+		return 0, true, nil
+	}
+
+	released = e.held
+	e.sniffing, e.held = false, nil
+	if err == nil {
+		e.Name = name
+		a, domain := match(name)
+		if sess.Proto == header.UDPProtocolNumber || a == Drop {
+			e.Action, e.Domain = a, domain
+		}
+	}
+	return e.Action, false, released
+}
+
+// held packets of sniffing flow
+type held struct {
+	sess   Session
+	action Action
+	ips    [][]byte
+}
+
+// expired release held packets of the flows that sniffing timeout, avoid silent
+// flow's packets be held forever
+func (s *sessions) expired() (hs []held) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range s.sessions {
+		if e.sniffing && len(e.held) > 0 && now.Sub(e.heldAt) > sniffTimeout {
+			hs = append(hs, held{sess: e.Session, action: e.Action, ips: e.held})
+			e.sniffing, e.held = false, nil
+		}
+	}
+	return hs
+}
+
+// spliced decide spliced tcp flow's action by sniffed name, see splicer
+func (s *sessions) spliced(sess Session, name string, match matcher) Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, _ := s.get(sess)
+	e.Name, e.sniffing = name, false
+	e.Action, e.Domain = match(name)
+	e.matched[0] = true
+	return e.Action
+}
+
+// get get or create session, require lock
+func (s *sessions) get(sess Session) (e *session, has bool) {
+	now := time.Now()
+	if now.Sub(s.cleaned) > sessionIdle {
+		for k, v := range s.sessions {
+			if now.Sub(v.last) > sessionIdle {
+				delete(s.sessions, k)
+			}
+		}
+		s.cleaned = now
+	}
+
+	e, has = s.sessions[sess]
+	if !has {
+		e = &session{SessionInfo: SessionInfo{Session: sess}}
+		s.sessions[sess] = e
+	}
+	e.last = now
+	return e, has
+}
+
+// downlink get inbound packet's action, sess is the flow's outbound session
+func (s *sessions) downlink(sess Session, match matcher) Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, _ := s.get(sess)
+	if !e.matched[1] {
+		e.inbound, _ = match(e.Name)
+		e.matched[1] = true
+	}
+	return e.inbound
+}
+
+// invalidate rules replaced, existing flows be re-matched
+func (s *sessions) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.sessions {
+		e.matched = [2]bool{}
+	}
+}
+
+func (s *sessions) list() []SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ss = make([]SessionInfo, 0, len(s.sessions))
+	for _, e := range s.sessions {
+		ss = append(ss, e.SessionInfo)
+	}
+	return ss
+}
+
+func transportPayload(ip []byte, proto tcpip.TransportProtocolNumber) []byte {
+	b := ipPayload(ip)
+	switch proto {
+	case header.TCPProtocolNumber:
+		if len(b) < header.TCPMinimumSize {
+			return nil
+		}
+		return header.TCP(b).Payload()
+	case header.UDPProtocolNumber:
+		if len(b) < header.UDPMinimumSize {
+			return nil
+		}
+		return header.UDP(b).Payload()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package fatun
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Sessions_Replace(t *testing.T) {
+	var (
+		r  = NewRules(Rule{Action: Direct, Dst: []netip.Prefix{netip.MustParsePrefix("8.8.8.0/24")}})
+		ss = newSessions()
+		s  = Session{
+			Src:   netip.MustParseAddrPort("10.0.0.2:1234"),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.MustParseAddrPort("8.8.8.8:80"),
+		}
+		ip      = tcpIP(t, s, header.TCPFlagAck).Bytes()
+		in      = Session{Src: s.Dst, Proto: s.Proto, Dst: s.Src}
+		match   = func(name string) (Action, string) { return r.decide(s, Outbound, name) }
+		inbound = func(string) (Action, string) { return r.decide(in, Inbound, "") }
+	)
+	r.onReplace(ss.invalidate)
+
+	a, _, _ := ss.uplink(ip, s, false, match)
+	require.Equal(t, Direct, a)
+	require.Equal(t, Direct, ss.downlink(s, inbound))
+
+	// existing flow follow replaced rules
+	r.Replace([]Rule{{Action: Drop, Ports: []PortRange{{From: 80, To: 80}}}})
+	a, _, _ = ss.uplink(ip, s, false, match)
+	require.Equal(t, Drop, a)
+	require.Equal(t, Drop, ss.downlink(s, inbound))
+}
+
+func Test_Sessions_Domain(t *testing.T) {
+	var (
+		r  = NewRules(Rule{Action: Direct, Domain: []string{"*.example.com"}})
+		ss = newSessions()
+		s  = Session{
+			Src:   netip.MustParseAddrPort("10.0.0.2:1234"),
+			Proto: header.TCPProtocolNumber,
+			Dst:   netip.MustParseAddrPort("93.184.216.34:443"),
+		}
+		match = func(name string) (Action, string) { return r.decide(s, Outbound, name) }
+	)
+	r.DNS = NewDNSCache()
+	r.DNS.Put(s.Dst.Addr(), "cdn.example.org", time.Minute)
+	r.DNS.Put(s.Dst.Addr(), "www.example.com", time.Minute)
+
+	a, _, _ := ss.uplink(tcpIP(t, s, header.TCPFlagSyn).Bytes(), s, false, match)
+	require.Equal(t, Direct, a)
+	infos := ss.list()
+	require.Len(t, infos, 1)
+	require.Equal(t, "www.example.com", infos[0].Domain, "record the domain decided action")
+}
@@ -0,0 +1,326 @@
+package fatun
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+var (
+	// ErrSniffMore payload is truncated, require more packet
+	ErrSniffMore = errors.New("sniff require more data")
+
+	// ErrNotSniffed payload is not tls/http/quic, or not carry server name
+	ErrNotSniffed = errors.New("not sniffed")
+)
+
+// Sniff extract server name from flow's first payloads, tcp payloads is
+// tls ClientHello SNI or http Host, udp payloads is quic Initial SNI.
+func Sniff(proto tcpip.TransportProtocolNumber, payloads ...[]byte) (name string, err error) {
+	switch proto {
+	case header.TCPProtocolNumber:
+		b := bytes.Join(payloads, nil)
+		if len(b) == 0 {
+			return "", ErrSniffMore
+		}
+		if b[0] == 0x16 {
+			return sniffTLS(b)
+		}
+		return sniffHTTP(b)
+	case header.UDPProtocolNumber:
+		return sniffQUIC(payloads)
+	default:
+		return "", ErrNotSniffed
+	}
+}
+
+// sniffTLS parse tls record layer, ClientHello maybe span multiple records
+func sniffTLS(b []byte) (string, error) {
+	var hs []byte
+	for len(b) > 0 {
+		if len(b) < 5 {
+			return "", ErrSniffMore
+		} else if b[0] != 0x16 || b[1] != 0x03 {
+			return "", ErrNotSniffed
+		}
+		n := int(binary.BigEndian.Uint16(b[3:5]))
+		if len(b) < 5+n {
+			hs = append(hs, b[5:]...)
+			break
+		}
+		hs = append(hs, b[5:5+n]...)
+		b = b[5+n:]
+	}
+	return clientHelloSNI(hs)
+}
+
+// clientHelloSNI parse tls handshake message ClientHello, return server_name extension
+func clientHelloSNI(b []byte) (string, error) {
+	if len(b) < 4 {
+		return "", ErrSniffMore
+	} else if b[0] != 0x01 {
+		return "", ErrNotSniffed
+	}
+	n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	if len(b) < 4+n {
+		return "", ErrSniffMore
+	}
+	r := reader(b[4 : 4+n])
+
+	r.skip(2 + 32)       // version, random
+	r.skip(int(r.u8()))  // session id
+	r.skip(int(r.u16())) // cipher suites
+	r.skip(int(r.u8()))  // compression methods
+	exts := reader(r.next(int(r.u16())))
+	for len(exts) > 0 && r != nil {
+		typ, ext := exts.u16(), reader(exts.next(int(exts.u16())))
+		if exts == nil {
+			break
+		} else if typ != 0 { // server_name
+			continue
+		}
+
+		list := reader(ext.next(int(ext.u16())))
+		for len(list) > 0 {
+			typ, name := list.u8(), list.next(int(list.u16()))
+			if list == nil {
+				break
+			} else if typ == 0 { // host_name
+				return normalize(string(name)), nil
+			}
+		}
+	}
+	return "", ErrNotSniffed
+}
+
+var methods = []string{"GET", "POST", "PUT", "HEAD", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+func sniffHTTP(b []byte) (string, error) {
+	method, _, found := bytes.Cut(b, []byte(" "))
+	if !found {
+		if len(b) > 8 {
+			return "", ErrNotSniffed
+		}
+		return "", ErrSniffMore
+	} else if !slices.Contains(methods, string(method)) {
+		return "", ErrNotSniffed
+	}
+
+	head, _, found := bytes.Cut(b, []byte("\r\n\r\n"))
+	lines := strings.Split(string(head), "\r\n")
+	if !found {
+		lines = lines[:len(lines)-1] // last line maybe incomplete
+	}
+	for _, line := range lines[min(1, len(lines)):] {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(k)) == "Host" {
+			host := strings.TrimSpace(v)
+			if h, _, ok := strings.Cut(host, ":"); ok && !strings.HasPrefix(host, "[") {
+				host = h // remove port
+			}
+			return normalize(host), nil
+		}
+	}
+	if found {
+		return "", ErrNotSniffed
+	}
+	return "", ErrSniffMore
+}
+
+// quic v1 initial salt, see rfc 9001 section 5.2
+var quicSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+// sniffQUIC decrypt quic v1 Initial packets, reassemble CRYPTO frames
+// as ClientHello, the ClientHello maybe span multiple datagrams.
+func sniffQUIC(datagrams [][]byte) (string, error) {
+	var crypto = map[uint64][]byte{}
+	for _, b := range datagrams {
+		if err := quicInitial(b, crypto); err != nil {
+			return "", err
+		}
+	}
+
+	var hello []byte
+	for {
+		data, has := crypto[uint64(len(hello))]
+		if !has || len(data) == 0 {
+			break
+		}
+		hello = append(hello, data...)
+	}
+	return clientHelloSNI(hello)
+}
+
+// quicInitial decrypt coalesced Initial packets in datagram, collect CRYPTO frame
+func quicInitial(b []byte, crypto map[uint64][]byte) error {
+	for len(b) > 0 && b[0] != 0 { // zero tail is padding
+		if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+			return ErrNotSniffed
+		} else if binary.BigEndian.Uint32(b[1:5]) != 1 {
+			return ErrNotSniffed // only v1
+		} else if (b[0]>>4)&0b11 != 0 {
+			return nil // not Initial, e.g. 0-RTT
+		}
+
+		r := reader(b[5:])
+		dcid := r.next(int(r.u8()))
+		r.skip(int(r.u8()))     // scid
+		r.skip(int(r.varint())) // token
+		n := int(r.varint())
+		if r == nil || len(r) < n || n < 4+16 {
+			return ErrNotSniffed
+		}
+		pnOffset := len(b) - len(r)
+		packet, payload := b[:pnOffset+n], r[:n]
+		b = b[pnOffset+n:]
+
+		key, iv, hp := quicKeys(dcid)
+		block, _ := aes.NewCipher(hp)
+		var mask [16]byte
+		block.Encrypt(mask[:], payload[4:4+16])
+
+		hdr := slices.Clone(packet[:pnOffset+4])
+		hdr[0] ^= mask[0] & 0x0f
+		pnLen := int(hdr[0]&0b11) + 1
+		var pn uint64
+		for i := 0; i < pnLen; i++ {
+			hdr[pnOffset+i] ^= mask[1+i]
+			pn = pn<<8 | uint64(hdr[pnOffset+i])
+		}
+		hdr = hdr[:pnOffset+pnLen]
+
+		nonce := slices.Clone(iv)
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+		}
+		block, _ = aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		frames, err := aead.Open(nil, nonce, payload[pnLen:], hdr)
+		if err != nil {
+			return ErrNotSniffed
+		}
+
+		if err := quicFrames(frames, crypto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quicFrames(b []byte, crypto map[uint64][]byte) error {
+	r := reader(b)
+	for len(r) > 0 {
+		switch typ := r.varint(); typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x06: // CRYPTO
+			off := r.varint()
+			data := r.next(int(r.varint()))
+			if r == nil {
+				return ErrNotSniffed
+			}
+			crypto[off] = data
+		case 0x02, 0x03: // ACK
+			r.varint() // largest acknowledged
+			r.varint() // delay
+			n := r.varint()
+			r.varint() // first range
+			for i := uint64(0); i < n && r != nil; i++ {
+				r.varint() // gap
+				r.varint() // range
+			}
+			if typ == 0x03 {
+				r.varint() // ect0
+				r.varint() // ect1
+				r.varint() // ce
+			}
+		default:
+			return nil
+		}
+		if r == nil {
+			return ErrNotSniffed
+		}
+	}
+	return nil
+}
+
+func quicKeys(dcid []byte) (key, iv, hp []byte) {
+	initial := hmacSHA256(quicSalt, dcid)
+	client := hkdfExpandLabel(initial, "client in", 32)
+	return hkdfExpandLabel(client, "quic key", 16),
+		hkdfExpandLabel(client, "quic iv", 12),
+		hkdfExpandLabel(client, "quic hp", 16)
+}
+
+// hkdfExpandLabel tls1.3 HKDF-Expand-Label with empty context, length not greater than 32
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{0, byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0, 1) // empty context, counter
+	return hmacSHA256(secret, info)[:length]
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// reader bytes reader, become nil after read out of range
+type reader []byte
+
+func (r *reader) next(n int) []byte {
+	if *r == nil || n > len(*r) {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *reader) skip(n int) { r.next(n) }
+
+func (r *reader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// varint quic variable-length integer
+func (r *reader) varint() uint64 {
+	if *r == nil || len(*r) == 0 {
+		*r = nil
+		return 0
+	}
+	b := r.next(1 << ((*r)[0] >> 6))
+	if b == nil {
+		return 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, e := range b[1:] {
+		v = v<<8 | uint64(e)
+	}
+	return v
+}
@@ -0,0 +1,124 @@
+package fatun
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func clientHello(t *testing.T, sni string) []byte {
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, &tls.Config{ServerName: sni}).Handshake()
+		c.Close()
+	}()
+
+	var b = make([]byte, 0xffff)
+	n, err := s.Read(b)
+	require.NoError(t, err)
+	return b[:n]
+}
+
+// quicInitialPacket build client Initial packet carry crypto data, rfc 9001 section 5
+func quicInitialPacket(t *testing.T, dcid []byte, off int, crypto []byte) []byte {
+	var frames = []byte{0x06}
+	frames = binary.BigEndian.AppendUint16(frames, 0x4000|uint16(off))
+	frames = binary.BigEndian.AppendUint16(frames, 0x4000|uint16(len(crypto)))
+	frames = append(frames, crypto...)
+	frames = append(frames, make([]byte, 32)...) // padding
+
+	const pn = 2
+	hdr := []byte{0xc0 | (pn - 1), 0, 0, 0, 1, byte(len(dcid))}
+	hdr = append(hdr, dcid...)
+	hdr = append(hdr, 0, 0) // scid, token
+	hdr = binary.BigEndian.AppendUint16(hdr, 0x4000|uint16(pn+len(frames)+16))
+	pnOffset := len(hdr)
+	hdr = append(hdr, 0, 7)
+
+	key, iv, hp := quicKeys(dcid)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := slices.Clone(iv)
+	nonce[len(nonce)-1] ^= 7
+	packet := aead.Seal(hdr, nonce, frames, hdr)
+
+	block, err = aes.NewCipher(hp)
+	require.NoError(t, err)
+	var mask [16]byte
+	block.Encrypt(mask[:], packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < pn; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+func Test_QUIC_Keys(t *testing.T) {
+	// rfc 9001 appendix A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	key, iv, hp := quicKeys(dcid)
+	require.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(key))
+	require.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(iv))
+	require.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(hp))
+}
+
+func Test_Sniff(t *testing.T) {
+	t.Run("tls", func(t *testing.T) {
+		b := clientHello(t, "www.Example.com")
+
+		name, err := Sniff(header.TCPProtocolNumber, b)
+		require.NoError(t, err)
+		require.Equal(t, "www.example.com", name)
+
+		_, err = Sniff(header.TCPProtocolNumber, b[:len(b)/2])
+		require.ErrorIs(t, err, ErrSniffMore)
+
+		name, err = Sniff(header.TCPProtocolNumber, b[:len(b)/2], b[len(b)/2:])
+		require.NoError(t, err)
+		require.Equal(t, "www.example.com", name)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		b := []byte("GET / HTTP/1.1\r\nhost: example.com:8080\r\nAccept: */*\r\n\r\n")
+
+		name, err := Sniff(header.TCPProtocolNumber, b)
+		require.NoError(t, err)
+		require.Equal(t, "example.com", name)
+
+		_, err = Sniff(header.TCPProtocolNumber, b[:20])
+		require.ErrorIs(t, err, ErrSniffMore)
+
+		_, err = Sniff(header.TCPProtocolNumber, []byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		require.ErrorIs(t, err, ErrNotSniffed)
+	})
+
+	t.Run("quic", func(t *testing.T) {
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		hello := clientHello(t, "quic.example.com")[5:] // strip record layer
+
+		name, err := Sniff(header.UDPProtocolNumber, quicInitialPacket(t, dcid, 0, hello))
+		require.NoError(t, err)
+		require.Equal(t, "quic.example.com", name)
+
+		// ClientHello span multiple datagrams
+		n := len(hello) / 2
+		p1 := quicInitialPacket(t, dcid, 0, hello[:n])
+		p2 := quicInitialPacket(t, dcid, n, hello[n:])
+		_, err = Sniff(header.UDPProtocolNumber, p1)
+		require.ErrorIs(t, err, ErrSniffMore)
+		name, err = Sniff(header.UDPProtocolNumber, p2, p1)
+		require.NoError(t, err)
+		require.Equal(t, "quic.example.com", name)
+	})
+}